@@ -0,0 +1,141 @@
+package dga
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// awsCredentialsFileName and awsConfigFileName are the file names the AWS CLI uses in ~/.aws.
+	awsCredentialsFileName = "credentials"
+	awsConfigFileName      = "config"
+	// awsDefaultProfile is the profile used when AWS_PROFILE is not set.
+	awsDefaultProfile = "default"
+)
+
+// awsProfile is one named profile of the AWS shared credentials and config files.
+type awsProfile struct {
+	name        string
+	credentials [][2]string
+	config      [][2]string
+	expiresAt   string
+}
+
+// awsProfiles collects the profiles of aws entries, written once every entry has been processed.
+type awsProfiles struct {
+	profiles []awsProfile
+}
+
+// validateAWSProfile checks the profile fields of an aws entry.
+func (s *SecretToRetrieve) validateAWSProfile() error {
+	if s.Profile == "" {
+		if s.Region != "" || len(s.ProfileConfig) > 0 {
+			return errors.New("region and profileConfig require profile")
+		}
+		return nil
+	}
+	if s.Type != EntryTypeAWS {
+		return errors.New("profile can only be used with aws entries")
+	}
+	if !validININame(s.Profile) {
+		return fmt.Errorf("invalid profile name %q", s.Profile)
+	}
+	for k := range s.ProfileConfig {
+		if !validININame(k) {
+			return fmt.Errorf("invalid profileConfig key %q", k)
+		}
+	}
+	return nil
+}
+
+// validININame reports whether s can be used as an INI section or key name.
+func validININame(s string) bool {
+	return s != "" && !strings.ContainsAny(s, "[]=#; \t\r\n")
+}
+
+// add adds a profile with credentials made of the lower case AWS variable names and values.
+func (p *awsProfiles) add(item SecretToRetrieve, variables [][2]string, expiresAt string) error {
+	for _, existing := range p.profiles {
+		if existing.name == item.Profile {
+			return fmt.Errorf("profile %q is used by more than one entry", item.Profile)
+		}
+	}
+	profile := awsProfile{name: item.Profile, expiresAt: expiresAt}
+	for _, kv := range variables {
+		profile.credentials = append(profile.credentials, [2]string{strings.ToLower(kv[0]), kv[1]})
+	}
+	if item.Region != "" {
+		profile.config = append(profile.config, [2]string{"region", item.Region})
+	}
+	keys := make([]string, 0, len(item.ProfileConfig))
+	for k := range item.ProfileConfig {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		profile.config = append(profile.config, [2]string{k, item.ProfileConfig[k]})
+	}
+	for _, kv := range append(profile.credentials, profile.config...) {
+		if strings.ContainsAny(kv[1], "\r\n") {
+			return fmt.Errorf("value of %q in profile %q spans lines", kv[0], item.Profile)
+		}
+	}
+	p.profiles = append(p.profiles, profile)
+	return nil
+}
+
+// write writes the credentials and config files to dir and exports AWS_SHARED_CREDENTIALS_FILE and
+// AWS_CONFIG_FILE. It does nothing when there are no profiles.
+func (p *awsProfiles) write(log *slog.Logger, out exporter, dir string) error {
+	if len(p.profiles) == 0 {
+		return nil
+	}
+	var credentials, config strings.Builder
+	for _, profile := range p.profiles {
+		comment := ""
+		if profile.expiresAt != "" {
+			comment = "expires " + profile.expiresAt
+		}
+		writeINISection(&credentials, comment, profile.name, profile.credentials)
+		section := "profile " + profile.name
+		if profile.name == awsDefaultProfile {
+			section = awsDefaultProfile
+		}
+		writeINISection(&config, "", section, profile.config)
+	}
+
+	for _, f := range []struct{ variable, name, content string }{
+		{"AWS_SHARED_CREDENTIALS_FILE", awsCredentialsFileName, credentials.String()},
+		{"AWS_CONFIG_FILE", awsConfigFileName, config.String()},
+	} {
+		path := filepath.Join(dir, f.name)
+		if err := out.WriteFile(path, []byte(f.content), PermissionReadWriteOwner); err != nil {
+			log.Error("unable to write aws profile files", logKeyPhase, phaseWrite, logKeyError, err)
+			return fmt.Errorf("cannot write aws profile files")
+		}
+		if err := out.Export(f.variable, path); err != nil {
+			log.Error("unable to export env variable", logKeyPhase, phaseExport, "variable", f.variable, logKeyError, err)
+			return fmt.Errorf("cannot set environment variable")
+		}
+	}
+	log.Info("wrote aws profiles", logKeyPhase, phaseWrite, "profiles", len(p.profiles), "dir", dir, logKeyStatus, statusSuccess)
+	return nil
+}
+
+// writeINISection appends a section to b, preceded by a blank line and comment when set.
+func writeINISection(b *strings.Builder, comment, name string, values [][2]string) {
+	if b.Len() > 0 {
+		b.WriteString("\n")
+	}
+	if comment != "" {
+		fmt.Fprintf(b, "# %s\n", comment)
+	}
+	fmt.Fprintf(b, "[%s]\n", name)
+	for _, kv := range values {
+		fmt.Fprintf(b, "%s = %s\n", kv[0], kv[1])
+	}
+}
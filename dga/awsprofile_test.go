@@ -0,0 +1,104 @@
+package dga_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/pterm/pterm"
+
+	dga "github.com/DelineaXPM/dsv-gitlab/dga"
+	"github.com/DelineaXPM/dsv-gitlab/dsv/dsvtest"
+)
+
+func TestRunAWSProfiles(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.SetSecret("aws:dev", map[string]interface{}{"accessKeyId": "AKIADEV", "secretAccessKey": "dev-secret"})
+	server.SetSecret("aws:prod", map[string]interface{}{"accessKeyId": "AKIAPROD", "secretAccessKey": "prod-secret", "sessionToken": "prod-session", "expiration": "2030-01-01T00:00:00Z"})
+	opts := hermeticRun(t, server, map[string]string{"DSV_RETRIEVE": `[
+		{"type": "aws", "secretPath": "aws:dev", "profile": "default", "region": "eu-west-1"},
+		{"type": "aws", "secretPath": "aws:prod", "profile": "prod", "region": "us-east-1",
+		 "profileConfig": {"output": "json", "duration_seconds": "900"}}
+	]`})
+
+	is.NoErr(dga.Run(context.Background(), opts))
+
+	dir := filepath.Join(opts.Root, ".aws")
+	vars := readDotEnv(t, opts)
+	is.Equal(vars, map[string]string{
+		"AWS_SHARED_CREDENTIALS_FILE": filepath.Join(dir, "credentials"),
+		"AWS_CONFIG_FILE":             filepath.Join(dir, "config"),
+	}) // Profile credentials should not be exported as variables.
+
+	credentials, err := os.ReadFile(vars["AWS_SHARED_CREDENTIALS_FILE"])
+	is.NoErr(err)
+	is.Equal(string(credentials), `[default]
+aws_access_key_id = AKIADEV
+aws_secret_access_key = dev-secret
+
+# expires 2030-01-01T00:00:00Z
+[prod]
+aws_access_key_id = AKIAPROD
+aws_secret_access_key = prod-secret
+aws_session_token = prod-session
+`)
+	config, err := os.ReadFile(vars["AWS_CONFIG_FILE"])
+	is.NoErr(err)
+	is.Equal(string(config), `[default]
+region = eu-west-1
+
+[profile prod]
+region = us-east-1
+duration_seconds = 900
+output = json
+`)
+	info, err := os.Stat(vars["AWS_SHARED_CREDENTIALS_FILE"])
+	is.NoErr(err)
+	is.Equal(info.Mode().Perm(), os.FileMode(0o600)) // Credentials should only be readable by the owner.
+}
+
+func TestRunAWSProfilesDuplicate(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.SetSecret("aws:dev", map[string]interface{}{"accessKeyId": "AKIADEV", "secretAccessKey": "dev-secret"})
+	opts := hermeticRun(t, server, map[string]string{
+		"DSV_AWS_PROFILE_DIR": "aws",
+		"DSV_RETRIEVE": `[
+			{"type": "aws", "secretPath": "aws:dev", "profile": "dev"},
+			{"type": "aws", "secretPath": "aws:dev", "profile": "dev"}
+		]`,
+	})
+
+	is.True(dga.Run(context.Background(), opts) != nil) // Should reject the second profile.
+	_, err := os.Stat(filepath.Join(opts.Root, "aws", "credentials"))
+	is.True(os.IsNotExist(err)) // No files should be written.
+}
+
+func TestParseRetrieveAWSProfile(t *testing.T) {
+	pterm.DisableOutput()
+	cases := []struct {
+		name     string
+		retrieve string
+		wantErr  bool
+	}{
+		{name: "valid", retrieve: `[{"type": "aws", "secretPath": "aws:dev", "profile": "dev", "profileConfig": {"role_arn": "arn:aws:iam::1:role/x"}}]`},
+		{name: "not aws", retrieve: `[{"type": "azure", "secretPath": "azure:dev", "profile": "dev"}]`, wantErr: true},
+		{name: "region without profile", retrieve: `[{"type": "aws", "secretPath": "aws:dev", "region": "eu-west-1"}]`, wantErr: true},
+		{name: "invalid profile", retrieve: `[{"type": "aws", "secretPath": "aws:dev", "profile": "dev]\n[prod"}]`, wantErr: true},
+		{name: "invalid config key", retrieve: `[{"type": "aws", "secretPath": "aws:dev", "profile": "dev", "profileConfig": {"a = b": "c"}}]`, wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			_, err := dga.ParseRetrieve(tc.retrieve)
+			is.Equal(err != nil, tc.wantErr)
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package dga
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// clientCredentialsFile is the JSON written by `dsv client create --plain`.
+//
+//nolint:tagliatelle // DSV uses camel casing.
+type clientCredentialsFile struct {
+	ClientID     string `json:"clientId"`
+	ClientSecret string `json:"clientSecret"`
+}
+
+// ResolveCredentials fills ClientIDEnv and ClientSecretEnv from DSV_CLIENT_ID_FILE, DSV_CLIENT_SECRET_FILE
+// or DSV_CREDENTIALS_FILE, making sure each value comes from exactly one source.
+func (cfg *Config) ResolveCredentials() error {
+	if cfg.CredentialsFile != "" {
+		if cfg.ClientIDEnv != "" || cfg.ClientSecretEnv != "" || cfg.ClientIDFile != "" || cfg.ClientSecretFile != "" {
+			return errors.New("DSV_CREDENTIALS_FILE cannot be combined with DSV_CLIENT_ID, DSV_CLIENT_SECRET, DSV_CLIENT_ID_FILE or DSV_CLIENT_SECRET_FILE")
+		}
+		b, err := os.ReadFile(cfg.CredentialsFile)
+		if err != nil {
+			return fmt.Errorf("unable to read DSV_CREDENTIALS_FILE: %w", err)
+		}
+		creds := clientCredentialsFile{}
+		if err := json.Unmarshal(b, &creds); err != nil {
+			// Never include the content, it holds the secret.
+			return errors.New("DSV_CREDENTIALS_FILE is not valid JSON, expected the output of `dsv client create --plain`")
+		}
+		cfg.ClientIDEnv, cfg.ClientSecretEnv = creds.ClientID, creds.ClientSecret
+	}
+
+	var err error
+	if cfg.ClientIDEnv, err = valueOrFile("DSV_CLIENT_ID", cfg.ClientIDEnv, cfg.ClientIDFile); err != nil {
+		return err
+	}
+	if cfg.ClientSecretEnv, err = valueOrFile("DSV_CLIENT_SECRET", cfg.ClientSecretEnv, cfg.ClientSecretFile); err != nil {
+		return err
+	}
+	return nil
+}
+
+// valueOrFile returns value, or the trimmed content of file when that is set instead. Exactly one must be set.
+func valueOrFile(name, value, file string) (string, error) {
+	switch {
+	case value != "" && file != "":
+		return "", fmt.Errorf("%s and %s_FILE are mutually exclusive, set only one", name, name)
+	case file != "":
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("unable to read %s_FILE: %w", name, err)
+		}
+		value = strings.TrimSpace(string(b))
+		if value == "" {
+			return "", fmt.Errorf("%s_FILE %s is empty", name, file)
+		}
+		return value, nil
+	case value == "":
+		return "", fmt.Errorf("%s is required, or set %s_FILE or DSV_CREDENTIALS_FILE", name, name)
+	default:
+		return value, nil
+	}
+}
@@ -0,0 +1,80 @@
+package dga_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matryer/is"
+
+	dga "github.com/DelineaXPM/dsv-gitlab/dga"
+)
+
+func TestResolveCredentials(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	idFile := write("id", "file-id\n")
+	secretFile := write("secret", "file-secret\n")
+	emptyFile := write("empty", "\n")
+	credsFile := write("creds.json", `{"clientId":"json-id","clientSecret":"json-secret","accessGrantId":"x"}`)
+	badCredsFile := write("bad.json", `clientSecret=oops`)
+
+	cases := []struct {
+		name       string
+		cfg        dga.Config
+		wantID     string
+		wantSecret string
+		wantErr    bool
+	}{
+		{
+			name:       "env values",
+			cfg:        dga.Config{ClientIDEnv: "env-id", ClientSecretEnv: "env-secret"},
+			wantID:     "env-id",
+			wantSecret: "env-secret",
+		},
+		{
+			name:       "files",
+			cfg:        dga.Config{ClientIDFile: idFile, ClientSecretFile: secretFile},
+			wantID:     "file-id",
+			wantSecret: "file-secret",
+		},
+		{
+			name:       "env id and secret file",
+			cfg:        dga.Config{ClientIDEnv: "env-id", ClientSecretFile: secretFile},
+			wantID:     "env-id",
+			wantSecret: "file-secret",
+		},
+		{
+			name:       "credentials file",
+			cfg:        dga.Config{CredentialsFile: credsFile},
+			wantID:     "json-id",
+			wantSecret: "json-secret",
+		},
+		{name: "env and file for the same value", cfg: dga.Config{ClientIDEnv: "env-id", ClientIDFile: idFile, ClientSecretEnv: "s"}, wantErr: true},
+		{name: "credentials file with env", cfg: dga.Config{CredentialsFile: credsFile, ClientSecretEnv: "s"}, wantErr: true},
+		{name: "credentials file with file", cfg: dga.Config{CredentialsFile: credsFile, ClientIDFile: idFile}, wantErr: true},
+		{name: "invalid credentials file", cfg: dga.Config{CredentialsFile: badCredsFile}, wantErr: true},
+		{name: "missing file", cfg: dga.Config{ClientIDFile: filepath.Join(dir, "missing"), ClientSecretEnv: "s"}, wantErr: true},
+		{name: "empty file", cfg: dga.Config{ClientIDEnv: "id", ClientSecretFile: emptyFile}, wantErr: true},
+		{name: "missing secret", cfg: dga.Config{ClientIDEnv: "id"}, wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			err := tc.cfg.ResolveCredentials()
+			if tc.wantErr {
+				is.True(err != nil) // Should reject the configuration.
+				return
+			}
+			is.NoErr(err)
+			is.Equal(tc.cfg.ClientIDEnv, tc.wantID)
+			is.Equal(tc.cfg.ClientSecretEnv, tc.wantSecret)
+		})
+	}
+}
@@ -1,25 +1,28 @@
 package dga
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
+	"log/slog"
+	"maps"
 	"net/http"
-	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/DelineaXPM/dsv-gitlab/dsv"
 	env "github.com/caarlos0/env/v6"
-	"github.com/pterm/pterm"
 )
 
 // defaultTimeout defines default timeout for HTTP requests.
 const defaultTimeout = time.Second * 5
 
+// dsvClientHeader identifies this integration in the Delinea-DSV-Client request header.
+const dsvClientHeader = "gitlab-action"
+
 // PermissionReadWriteOwner is the octal permission for Read Write for the owner of the file.
 const PermissionReadWriteOwner = 0o600
 
@@ -27,174 +30,509 @@ type Config struct {
 	IsCI    bool `env:"GITLAB_CI"`      // IsCI determines if the system is detecting being in CI system. https://docs.gitlab.com/ee/ci/variables/#enable-debug-logging
 	IsDebug bool `env:"CI_DEBUG_TRACE"` // IsDebug is based on gitlab flagging as debug/trace level.
 
-	CIProjectDirectory string `env:"CI_PROJECT_DIR,notEmpty"` // CIProjectDirectory is populated by CI_PROJECT_DIR which provides the fully qualified path to the project. https://docs.gitlab.com/ee/ci/variables/
-	CIJobName          string `env:"CI_JOB_NAME,notEmpty"`    // CIJobName is populated by CI_JOB_NAME which provides the fully qualified path to the project. https://docs.gitlab.com/ee/ci/variables/
+	CIProjectDirectory string `env:"CI_PROJECT_DIR"` // CIProjectDirectory is populated by CI_PROJECT_DIR which provides the fully qualified path to the project. Required unless running in local mode. https://docs.gitlab.com/ee/ci/variables/
+	CIJobName          string `env:"CI_JOB_NAME"`    // CIJobName is populated by CI_JOB_NAME which provides the fully qualified path to the project. Required unless running in local mode. https://docs.gitlab.com/ee/ci/variables/
 	// DSV SPECIFIC ENV VARIABLES.
 
-	DomainEnv       string `env:"DSV_DOMAIN,notEmpty"`                 // Tenant domain name (e.g. example.secretsvaultcloud.com).
-	ClientIDEnv     string `env:"DSV_CLIENT_ID,notEmpty"`              // Client ID for authentication.
-	ClientSecretEnv string `json:"-" env:"DSV_CLIENT_SECRET,notEmpty"` // Client Secret for authentication.
-	RetrieveEnv     string `env:"DSV_RETRIEVE,notEmpty"`               // JSON formatted string with data to retrieve from DSV.
+	DomainEnv        string `env:"DSV_DOMAIN"`                 // Tenant domain name (e.g. example.secretsvaultcloud.com). Required unless DSV_API_URL is set.
+	APIURLEnv        string `env:"DSV_API_URL"`                // Full API base URL (e.g. http://localhost:8080/v1), overrides DSV_DOMAIN.
+	ClientIDEnv      string `env:"DSV_CLIENT_ID"`              // Client ID for authentication.
+	ClientSecretEnv  string `json:"-" env:"DSV_CLIENT_SECRET"` // Client Secret for authentication.
+	ClientIDFile     string `env:"DSV_CLIENT_ID_FILE"`         // File containing the Client ID, alternative to DSV_CLIENT_ID.
+	ClientSecretFile string `env:"DSV_CLIENT_SECRET_FILE"`     // File containing the Client Secret, alternative to DSV_CLIENT_SECRET.
+	CredentialsFile  string `env:"DSV_CREDENTIALS_FILE"`       // JSON file from `dsv client create --plain`, alternative to the client ID and secret variables.
+	RetrieveEnv      string `env:"DSV_RETRIEVE"`               // JSON formatted string with data to retrieve from DSV.
+	StoreEnv         string `env:"DSV_STORE"`                  // JSON or YAML spec of the secret to write with the store command.
+	RotateEnv        string `env:"DSV_ROTATE"`                 // JSON or YAML spec of the secret key to rotate with the rotate command.
+
+	AllowCustomDomain bool `env:"DSV_ALLOW_CUSTOM_DOMAIN"` // Accept a DSV_DOMAIN outside the known DSV cloud domains.
+
+	GitCredentialsEnv     string `env:"DSV_GIT_CREDENTIALS"`         // JSON or YAML list mapping git hosts and paths to secrets for the git-credential command.
+	DockerCredentialsFile string `env:"DSV_DOCKER_CREDENTIALS_FILE"` // JSON or YAML file mapping registries to secrets for the docker credential helper.
+
+	// TLS AND PROXY SETTINGS.
+
+	CACert        string   `env:"DSV_CA_CERT"`                          // PEM encoded CA certificates to trust in addition to the system pool.
+	CACertFile    string   `env:"DSV_CA_CERT_FILE"`                     // Path to a PEM encoded CA bundle to trust in addition to the system pool.
+	TLSMinVersion string   `env:"DSV_TLS_MIN_VERSION" envDefault:"1.2"` // Minimum TLS version, 1.2 or 1.3.
+	PinnedSPKI    []string `env:"DSV_TLS_PINNED_SPKI" envSeparator:","` // Comma separated base64 sha256 hashes of trusted public keys (optionally prefixed with sha256//).
+	HTTPSProxy    string   `env:"HTTPS_PROXY"`                          // Proxy for DSV requests, https_proxy is used if not set.
+	NoProxy       string   `env:"NO_PROXY"`                             // Hosts that bypass HTTPS_PROXY, no_proxy is used if not set.
+
+	TokenCache    bool   `env:"DSV_TOKEN_CACHE"`                             // TokenCache shares the access token between jobs of the same pipeline.
+	TokenCacheDir string `env:"DSV_TOKEN_CACHE_DIR" envDefault:".dsv-cache"` // TokenCacheDir holds the encrypted token cache, relative to CI_PROJECT_DIR unless absolute.
+	CIPipelineID  string `env:"CI_PIPELINE_ID"`                              // CIPipelineID scopes the token cache to one pipeline.
+
+	TotalTimeout time.Duration `env:"DSV_TOTAL_TIMEOUT"` // Deadline for the whole run (e.g. 2m), no deadline when unset.
+
+	AWSProfileDir   string `env:"DSV_AWS_PROFILE_DIR" envDefault:".aws"`      // AWSProfileDir receives the AWS credentials and config files, relative to CI_PROJECT_DIR unless absolute.
+	DockerConfigDir string `env:"DSV_DOCKER_CONFIG_DIR" envDefault:".docker"` // DockerConfigDir receives the Docker config.json, relative to CI_PROJECT_DIR unless absolute.
+	KubeconfigDir   string `env:"DSV_KUBECONFIG_DIR" envDefault:".kube"`      // KubeconfigDir receives the kubeconfig, relative to CI_PROJECT_DIR unless absolute.
+	JUnitReport     string `env:"DSV_JUNIT_REPORT"`                           // JUnitReport receives a JUnit XML report of the DSV_RETRIEVE entries, relative to CI_PROJECT_DIR unless absolute.
+
+	LogFormat string `env:"DSV_LOG_FORMAT"` // LogFormat is pretty (default), plain, text or json.
+	LogLevel  string `env:"DSV_LOG_LEVEL"`  // LogLevel is debug, info (default), warn or error. CI_DEBUG_TRACE always enables debug.
+
+	// Local is set when running outside of GitLab, either explicitly or because GITLAB_CI is not set.
+	Local bool `env:"-"`
+
+	// httpClient sends the DSV requests instead of a client built from the TLS and proxy settings, when set.
+	httpClient HTTPClient
+	// logger receives the log events of the command, see log.
+	logger *slog.Logger
+	// environment is what the configuration was parsed from, including the local sources.
+	environment map[string]string
+}
+
+// Options are the command line settings passed in from main.
+type Options struct {
+	Local      bool   // Local forces local development mode even when GITLAB_CI is set.
+	ShowValues bool   // ShowValues prints secret values in local mode instead of masking them.
+	OutFile    string // OutFile writes a local dotenv file instead of printing the resolved variables.
+	EnvFile    string // EnvFile is a .env file to read DSV_* variables from in local mode.
+	ConfigFile string // ConfigFile is the DSV CLI config file to read credentials from in local mode.
+	Profile    string // Profile is the DSV CLI profile to use from ConfigFile.
+
+	// Environment replaces the process environment when not nil, so the configuration can be injected.
+	Environment map[string]string
+	// HTTPClient sends the DSV requests instead of a client built from the TLS and proxy settings.
+	HTTPClient HTTPClient
+	// Root replaces CI_PROJECT_DIR as the directory outputs are written to, and is the directory
+	// relative local files such as EnvFile and OutFile are resolved against.
+	Root string
+	// Logger receives the log events instead of a logger built from DSV_LOG_FORMAT and DSV_LOG_LEVEL.
+	Logger *slog.Logger
+
+	// helper is set by the credential helpers, which only read the local sources given explicitly.
+	helper bool
 }
 
+const (
+	// EntryTypeSecret exports one key of a secret, the default entry type.
+	EntryTypeSecret = "secret"
+	// EntryTypePKI issues a leaf certificate from a DSV PKI root.
+	EntryTypePKI = "pki"
+	// EntryTypeSSH signs an ephemeral SSH key with a DSV PKI root.
+	EntryTypeSSH = "ssh"
+)
+
 // SecretToRetrieve defines JSON format of elements that expected in DSV_RETRIEVE list.
 //
 //nolint:tagliatelle // Here 'camel' casing is used instead of 'kebab'.
 type SecretToRetrieve struct {
+	Type           string `json:"type,omitempty"` // Type is secret (default), pki, ssh, aws, azure, gcp, docker or kube.
 	SecretPath     string `json:"secretPath"`
 	SecretKey      string `json:"secretKey"`
 	OutputVariable string `json:"outputVariable"`
+
+	// PKI ENTRIES.
+
+	RootCAPath      string   `json:"rootCAPath,omitempty"`      // RootCAPath is the DSV PKI root that signs the certificate.
+	CommonName      string   `json:"commonName,omitempty"`      // CommonName is the certificate subject common name.
+	SubjectAltNames []string `json:"subjectAltNames,omitempty"` // SubjectAltNames are DNS names, IP addresses, email addresses or URIs.
+	TTL             string   `json:"ttl,omitempty"`             // TTL is how long the certificate or credentials are valid, such as 30m or 12h.
+	KeyAlgorithm    string   `json:"keyAlgorithm,omitempty"`    // KeyAlgorithm is rsa (default) or ecdsa.
+	OutputDir       string   `json:"outputDir,omitempty"`       // OutputDir receives the files, relative to CI_PROJECT_DIR unless absolute.
+
+	// SSH ENTRIES, also using RootCAPath, TTL and OutputDir.
+
+	LeafCAPath string   `json:"leafCAPath,omitempty"` // LeafCAPath is the DSV PKI leaf CA under RootCAPath, if any.
+	Principals []string `json:"principals,omitempty"` // Principals are the user names the certificate is valid for.
+	Hosts      []string `json:"hosts,omitempty"`      // Hosts are host patterns to write an ssh config for.
+	HostCAKey  string   `json:"hostCAKey,omitempty"`  // HostCAKey is the public key of the CA that signs host keys, written to known_hosts.
+
+	// AWS, AZURE AND GCP ENTRIES read the dynamic secret at SecretPath, using TTL, OutputDir and OutputVariable as a prefix.
+
+	Profile       string            `json:"profile,omitempty"`       // Profile writes aws credentials to this profile of the shared files instead of exporting them.
+	Region        string            `json:"region,omitempty"`        // Region is the profile region.
+	ProfileConfig map[string]string `json:"profileConfig,omitempty"` // ProfileConfig holds other profile settings, such as role_arn.
+
+	// DOCKER ENTRIES, also using SecretPath.
+
+	Registry    string `json:"registry,omitempty"`    // Registry is the registry host the credentials are for.
+	UsernameKey string `json:"usernameKey,omitempty"` // UsernameKey is the data key holding the user name, username by default.
+	PasswordKey string `json:"passwordKey,omitempty"` // PasswordKey is the data key holding the password or token, password by default.
+
+	// KUBE ENTRIES, also using SecretPath.
+
+	Context        string `json:"context,omitempty"`        // Context names the kubeconfig context, cluster and user.
+	Namespace      string `json:"namespace,omitempty"`      // Namespace is the context namespace, overriding the one in the secret.
+	CurrentContext bool   `json:"currentContext,omitempty"` // CurrentContext makes this the current context instead of the first one.
+}
+
+// validate checks the fields required by the entry type.
+func (s *SecretToRetrieve) validate() error {
+	if err := s.validateOutputVariable(); err != nil {
+		return err
+	}
+	if err := s.validateAWSProfile(); err != nil {
+		return err
+	}
+	switch s.Type {
+	case "", EntryTypeSecret:
+		return nil
+	case EntryTypePKI:
+		return s.validatePKI()
+	case EntryTypeSSH:
+		return s.validateSSH()
+	case EntryTypeAWS, EntryTypeAzure, EntryTypeGCP:
+		return s.validateDynamic()
+	case EntryTypeDocker:
+		return s.validateDocker()
+	case EntryTypeKube:
+		return s.validateKube()
+	default:
+		return fmt.Errorf("unsupported entry type %q, expected %s, %s, %s, %s, %s, %s, %s or %s",
+			s.Type, EntryTypeSecret, EntryTypePKI, EntryTypeSSH, EntryTypeAWS, EntryTypeAzure, EntryTypeGCP, EntryTypeDocker, EntryTypeKube)
+	}
+}
+
+// validateOutputVariable checks the exported name, so an entry that cannot be exported fails before any
+// secret is read. Secret entries need the name, the other types use it as an optional prefix.
+func (s *SecretToRetrieve) validateOutputVariable() error {
+	if s.OutputVariable == "" {
+		if s.Type == "" || s.Type == EntryTypeSecret {
+			return errors.New("secret entries require outputVariable")
+		}
+		return nil
+	}
+	if err := checkVariableName(s.OutputVariable); err != nil {
+		return fmt.Errorf("outputVariable: %w", err)
+	}
+	return nil
 }
 
 // getEnvFileName helps retrieve and build a env file path that should contain
 // the resulting secrets. See [GitLab - Passing An Environment Variable to Another Job](https://docs.gitlab.com/ee/ci/variables/#pass-an-environment-variable-to-another-job)
 func (cfg *Config) getEnvFileName() string {
 	envFileName := filepath.Join(cfg.CIProjectDirectory, cfg.CIJobName)
-	pterm.Debug.Printfln("envfilename: %s", envFileName)
-	pterm.Success.Printfln("getEnvFileName() success")
+	cfg.log().Debug("env file name", "file", envFileName)
 	return envFileName
 }
 
-// configure Pterm settings for project based on the detected environment.
-func (cfg *Config) configureLogging() {
-	pterm.Info.Println("configureLogging()")
-	pterm.Error = *pterm.Error.WithShowLineNumber().WithLineNumberOffset(1) //nolint:reassign // changing prefix later, not an issue.
-	pterm.Success.Printfln("configureLogging() success")
-}
-
-func (cfg *Config) sendRequest(c HTTPClient, req *http.Request, out any) error {
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Delinea-DSV-Client", "gitlab-action")
-	resp, err := c.Do(req)
+// configureLogging sets the logger of cfg to opts.Logger, or to one for DSV_LOG_FORMAT and
+// DSV_LOG_LEVEL writing to stderr. CI_DEBUG_TRACE enables debug events in any format.
+func (cfg *Config) configureLogging(opts Options) error {
+	if opts.Logger != nil {
+		cfg.logger = opts.Logger
+		return nil
+	}
+	l, err := NewLogger(cfg.LogFormat, cfg.LogLevel, cfg.IsDebug, os.Stderr)
 	if err != nil {
-		pterm.Error.Printfln("sendRequest: %+v", err)
 		return err
 	}
-	defer resp.Body.Close()
+	cfg.logger = l
+	return nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("%s %s: %s", req.Method, req.URL, resp.Status)
+// newDSVClient returns a DSV API client sending requests through c.
+func (cfg *Config) newDSVClient(c HTTPClient, apiEndpoint string) *dsv.Client {
+	return &dsv.Client{
+		BaseURL:      apiEndpoint,
+		HTTPClient:   c,
+		ClientHeader: dsvClientHeader,
+		Logger:       cfg.log(),
 	}
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		pterm.Error.Printfln("sendRequest() unable to read response body: %+v", err)
-		return fmt.Errorf("could not read response body: %w", err)
+// parseConfig reads the configuration from the environment. Once the environment is parsed, the
+// returned Config keeps the parsed settings even with an error, so Run can still report the failure.
+func parseConfig(opts Options) (Config, error) {
+	// Until DSV_LOG_FORMAT is known, events go to the injected logger or the default one.
+	cfg := Config{logger: opts.Logger}
+	if cfg.logger == nil {
+		cfg.logger = defaultLogger()
 	}
 
-	if err = json.Unmarshal(body, &out); err != nil {
-		pterm.Error.Printfln("Unmarshal(): %+v", err)
-		return fmt.Errorf("could not unmarshal response body: %w", err)
+	environment := toEnvMap(os.Environ())
+	if opts.Environment != nil {
+		environment = maps.Clone(opts.Environment)
 	}
-	pterm.Success.Printfln("sendRequest() success")
-	return nil
-}
+	local := opts.Local || environment["GITLAB_CI"] == ""
+	if local {
+		// Real environment variables always win over local sources.
+		if err := loadLocalSources(cfg.logger, environment, opts); err != nil {
+			return Config{}, err
+		}
+	}
+
+	// Support the lowercase proxy variables that curl and Go also accept.
+	mergeMissing(environment, map[string]string{
+		"HTTPS_PROXY": environment["https_proxy"],
+		"NO_PROXY":    environment["no_proxy"],
+	})
 
-func parseConfig() (Config, error) {
-	cfg := Config{}
-	cfg.configureLogging()
 	if err := env.Parse(&cfg, env.Options{
+		Environment: environment,
 		// Prefix: "DSV_",.
 	}); err != nil {
-		pterm.Error.Printfln("env.Parse() %+v", err)
+		cfg.log().Error("unable to parse environment variables", logKeyPhase, phaseConfig, logKeyError, err)
 		return Config{}, fmt.Errorf("unable to parse env vars: %w", err)
 	}
-	pterm.Success.Println("parsed environment variables")
+	cfg.Local = local
+	cfg.environment = environment
+	cfg.httpClient = opts.HTTPClient
+	if opts.Root != "" {
+		cfg.CIProjectDirectory = opts.Root
+	}
+	if err := cfg.configureLogging(opts); err != nil {
+		return cfg, err
+	}
+
+	if err := cfg.ResolveCredentials(); err != nil {
+		return cfg, err
+	}
+
+	if !cfg.Local {
+		if cfg.CIProjectDirectory == "" || cfg.CIJobName == "" {
+			return cfg, fmt.Errorf("CI_PROJECT_DIR and CI_JOB_NAME are required when running in GitLab, use --local to run without them")
+		}
+	}
+	cfg.log().Debug("parsed environment variables", logKeyPhase, phaseConfig, logKeyStatus, statusSuccess)
 	return cfg, nil
 }
 
-func Run() error { //nolint:funlen,cyclop // funlen: this could use refactoring in future to break it apart more, but leaving as is at this time.
-	cfg, err := parseConfig()
-	if err != nil {
-		return err
+// logDebug logs the configuration when debug events are enabled. Credentials and DSV_STORE,
+// which may contain values, are never logged.
+func (cfg *Config) logDebug() {
+	if !cfg.log().Enabled(context.Background(), slog.LevelDebug) {
+		return
 	}
-
 	if cfg.IsDebug {
-		pterm.Info.Println("DEBUG detected, setting debug output to enabled")
-		pterm.EnableDebugMessages()
-		pterm.Debug.Println("debug messages have been enabled")
+		cfg.log().Info("CI_DEBUG_TRACE detected, debug output enabled")
+	}
+	cfg.log().Debug("configuration", logKeyPhase, phaseConfig,
+		"GITLAB_CI", cfg.IsCI,
+		"CI_DEBUG_TRACE", cfg.IsDebug,
+		"local", cfg.Local,
+		"DSV_DOMAIN", cfg.DomainEnv,
+		"DSV_API_URL", cfg.APIURLEnv,
+		"DSV_ALLOW_CUSTOM_DOMAIN", cfg.AllowCustomDomain,
+		"DSV_CLIENT_ID_FILE", cfg.ClientIDFile,
+		"DSV_CLIENT_SECRET_FILE", cfg.ClientSecretFile,
+		"DSV_CREDENTIALS_FILE", cfg.CredentialsFile,
+		"DSV_RETRIEVE", cfg.RetrieveEnv,
+		"DSV_ROTATE", cfg.RotateEnv,
+		"DSV_GIT_CREDENTIALS", cfg.GitCredentialsEnv,
+		"DSV_DOCKER_CREDENTIALS_FILE", cfg.DockerCredentialsFile,
+		"DSV_CA_CERT_FILE", cfg.CACertFile,
+		"DSV_TLS_MIN_VERSION", cfg.TLSMinVersion,
+		"DSV_TLS_PINNED_SPKI", strings.Join(cfg.PinnedSPKI, ","),
+		"NO_PROXY", cfg.NoProxy,
+		"DSV_TOTAL_TIMEOUT", cfg.TotalTimeout,
+		"DSV_TOKEN_CACHE", cfg.TokenCache,
+		"DSV_TOKEN_CACHE_DIR", cfg.TokenCacheDir,
+		"DSV_AWS_PROFILE_DIR", cfg.AWSProfileDir,
+		"DSV_DOCKER_CONFIG_DIR", cfg.DockerConfigDir,
+		"DSV_KUBECONFIG_DIR", cfg.KubeconfigDir,
+		"DSV_JUNIT_REPORT", cfg.JUnitReport,
+		"DSV_LOG_FORMAT", cfg.LogFormat,
+		"DSV_LOG_LEVEL", cfg.LogLevel,
+	)
+}
 
-		// No %v to avoid exposing secret values.
-		pterm.Debug.Printfln("IsCI            : %v", cfg.IsCI)
-		pterm.Debug.Printfln("IsDebug         : %v", cfg.IsDebug)
+// withTimeout applies DSV_TOTAL_TIMEOUT to ctx when it is set.
+func (cfg *Config) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if cfg.TotalTimeout > 0 {
+		return context.WithTimeout(ctx, cfg.TotalTimeout)
+	}
+	return context.WithCancel(ctx)
+}
 
-		pterm.Debug.Printfln("DomainEnv       : %v", cfg.DomainEnv)
-		pterm.Debug.Println("ClientIDEnv     : ** value exists, but not exposing in logs **")
-		pterm.Debug.Println("ClientSecretEnv : ** value exists, but not exposing in logs **")
-		pterm.Debug.Printfln("RetrieveEnv     : %v", cfg.RetrieveEnv)
+// connect returns an authenticated DSV client, requesting the first access token up front so
+// authentication problems are reported before any secret is touched.
+func (cfg *Config) connect(ctx context.Context) (*dsv.Client, error) {
+	apiEndpoint, err := cfg.APIEndpoint()
+	if err != nil {
+		cfg.log().Error("invalid api endpoint", logKeyPhase, phaseConfig, logKeyError, err)
+		return nil, configError(err)
+	}
+	httpClient := cfg.httpClient
+	if httpClient == nil {
+		if httpClient, err = NewHTTPClient(cfg); err != nil {
+			cfg.log().Error("unable to configure http client", logKeyPhase, phaseConfig, logKeyError, err)
+			return nil, configError(err)
+		}
 	}
 
+	client := cfg.newDSVClient(httpClient, apiEndpoint)
+	auth := dsv.NewClientCredentials(client, cfg.ClientIDEnv, cfg.ClientSecretEnv)
+	auth.Cache = cfg.newTokenCache()
+	client.Auth = auth
+	start := time.Now()
+	if _, err := client.Auth.AccessToken(ctx); err != nil {
+		cfg.log().Error("authentication failure", logKeyPhase, phaseAuth, logKeyStatus, statusFailure, logKeyDuration, time.Since(start), logKeyError, err)
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("run cancelled: %w", ctx.Err())
+		}
+		// The token endpoint rejects bad credentials with 401 or 403. Any other status, such as a 404
+		// from a wrong DSV_API_URL path, points at the configuration rather than the credentials.
+		kind := requestErrorKind(err)
+		switch kind {
+		case ErrorNetwork, ErrorConfig:
+		case ErrorAuth, ErrorPermission:
+			kind = ErrorAuth
+		default:
+			kind = ErrorConfig
+		}
+		return nil, &Error{Kind: kind, Err: errors.New("unable to get access token")}
+	}
+	cfg.log().Debug("authenticated", logKeyPhase, phaseAuth, logKeyStatus, statusSuccess, logKeyDuration, time.Since(start))
+	return client, nil
+}
+
+// Run retrieves the secrets in DSV_RETRIEVE and exports them, stopping when ctx is cancelled or DSV_TOTAL_TIMEOUT passes.
+// Anything already written to the output file is removed when the run does not complete.
+func Run(ctx context.Context, opts Options) (err error) { //nolint:funlen,cyclop // funlen: this could use refactoring in future to break it apart more, but leaving as is at this time.
+	cfg, err := parseConfig(opts)
+	// The report is written as soon as DSV_JUNIT_REPORT is known, so a configuration error shows up in it too.
+	var results []entryResult
+	runStart := time.Now()
+	defer func() {
+		if reportErr := cfg.writeJUnitReport(results, time.Since(runStart), err); reportErr != nil {
+			cfg.log().Error("unable to write junit report", logKeyPhase, phaseWrite, logKeyError, reportErr)
+		}
+	}()
+	if err != nil {
+		return configError(err)
+	}
+
+	cfg.logDebug()
+
+	ctx, cancel := cfg.withTimeout(ctx)
+	defer cancel()
+
+	if cfg.RetrieveEnv == "" {
+		return configError(errors.New("DSV_RETRIEVE is required"))
+	}
 	retrievedValues, err := ParseRetrieve(cfg.RetrieveEnv)
 	if err != nil {
-		pterm.Error.Printfln("run failure: %v", err)
-		return err
+		cfg.log().Error("invalid DSV_RETRIEVE", logKeyPhase, phaseConfig, logKeyError, err)
+		return configError(err)
 	}
+	cfg.log().Debug("parsed DSV_RETRIEVE", logKeyPhase, phaseConfig, "entries", len(retrievedValues), "spec", fmt.Sprintf("%+v", retrievedValues))
 
-	apiEndpoint := fmt.Sprintf("https://%s/v1", cfg.DomainEnv)
-	httpClient := &http.Client{Timeout: defaultTimeout}
+	results = newEntryResults(retrievedValues)
+	defer func() { logSummary(cfg.log(), results, time.Since(runStart)) }()
+	defer cfg.logSection("dsv_retrieve", fmt.Sprintf("Retrieving %d entries from DSV", len(retrievedValues)))()
 
-	token, err := DSVGetToken(httpClient, apiEndpoint, &cfg)
+	client, err := cfg.connect(ctx)
 	if err != nil {
-		pterm.Error.Printfln("authentication failure: %v", err)
-		return fmt.Errorf("unable to get access token")
+		return err
+	}
+
+	out, closeOut, err := cfg.openExporter(opts)
+	if err != nil {
+		return err
 	}
+	defer closeOut()
 
-	var envFile *os.File
-	if cfg.IsCI {
-		envFile, err = OpenEnvFile(&cfg)
+	targets := &fileTargets{}
+	completed := false
+	defer func() {
+		if completed {
+			return
+		}
+		if err := out.Rollback(); err != nil {
+			cfg.log().Error("unable to roll back", logKeyPhase, phaseWrite, logKeyError, err)
+		}
+	}()
+
+	for i, item := range retrievedValues {
+		if ctx.Err() != nil {
+			return fmt.Errorf("run cancelled: %w", ctx.Err())
+		}
+		start := time.Now()
+		var err error
+		switch item.Type {
+		case EntryTypePKI:
+			err = cfg.issueCertificate(ctx, client, out, item)
+		case EntryTypeSSH:
+			err = cfg.signSSHKey(ctx, client, out, item)
+		case EntryTypeAWS, EntryTypeAzure, EntryTypeGCP:
+			err = cfg.exportCloudCredentials(ctx, client, out, &targets.aws, item)
+		case EntryTypeDocker:
+			err = cfg.addDockerAuth(ctx, client, &targets.docker, item)
+		case EntryTypeKube:
+			err = cfg.addKubeContext(ctx, client, &targets.kube, item)
+		default:
+			err = cfg.exportSecret(ctx, client, out, item)
+		}
+		results[i].done(cfg.log(), start, err)
 		if err != nil {
-			pterm.Error.Printfln("unable to run OpenEnvFile: %v", err)
+			if ctx.Err() != nil {
+				return fmt.Errorf("run cancelled: %w", ctx.Err())
+			}
 			return err
 		}
-		defer envFile.Close()
 	}
+	if err := targets.write(&cfg, out); err != nil {
+		return err
+	}
+	completed = true
+	return nil
+}
 
-	for _, item := range retrievedValues {
-		pterm.Debug.Printfln("start processing: SecretPath: %s SecretKey: %s", item.SecretPath, item.SecretKey)
-		secret, err := DSVGetSecret(httpClient, apiEndpoint, token, item, &cfg)
-		if err != nil {
-			pterm.Error.Printfln("%q: Failed to fetch secret: %v", item, err)
-			return fmt.Errorf("unable to get secret")
-		}
+// path returns the DSV path an entry reads, the PKI root for pki and ssh entries.
+func (s *SecretToRetrieve) path() string {
+	if s.Type == EntryTypePKI || s.Type == EntryTypeSSH {
+		return s.RootCAPath
+	}
+	return s.SecretPath
+}
 
-		secretData, ok := secret["data"].(map[string]interface{})
-		if !ok {
-			pterm.Error.Printfln("%q: Cannot get data from secret", item)
-			return fmt.Errorf("cannot parse secret")
-		}
-		pterm.Success.Printfln("retrieved successfully: %q", item)
+// entryType returns the entry type, secret when not set.
+func (s *SecretToRetrieve) entryType() string {
+	if s.Type == "" {
+		return EntryTypeSecret
+	}
+	return s.Type
+}
 
-		val, ok := secretData[item.SecretKey].(string)
-		if !ok {
-			pterm.Error.Printfln("%q: Key %q not found in data", item, item.SecretKey)
-			return fmt.Errorf("specified field was not found in data")
-		}
+// exportSecret reads the secret at item.SecretPath and exports the value of item.SecretKey.
+func (cfg *Config) exportSecret(ctx context.Context, client *dsv.Client, out exporter, item SecretToRetrieve) error {
+	log := cfg.log().With(logKeyPhase, phaseRetrieve, logKeyPath, item.SecretPath)
+	log.Debug("start processing", "key", item.SecretKey)
+	secret, err := client.GetSecret(ctx, item.SecretPath)
+	if err != nil {
+		log.Error("failed to fetch secret", logKeyError, err)
+		return requestError(err, "unable to get secret")
+	}
 
-		pterm.Debug.Printfln("%q: Found %q key in data", item, item.SecretKey)
+	if secret.Data == nil {
+		log.Error("cannot get data from secret")
+		return fmt.Errorf("cannot parse secret")
+	}
+	log.Debug("retrieved successfully")
 
-		if !cfg.IsCI {
-			continue
-		}
+	val, ok := secret.Data[item.SecretKey].(string)
+	if !ok {
+		log.Error("key not found in data", "key", item.SecretKey)
+		return notFoundError("specified field was not found in data")
+	}
 
-		outputKey := item.OutputVariable
+	log.Debug("found key in data", "key", item.SecretKey)
 
-		if err := ExportEnvVariable(envFile, outputKey, val); err != nil {
-			pterm.Error.Printfln("%q: unable to export env variable: %v", outputKey, err)
-			return fmt.Errorf("cannot set environment variable")
-		}
-		pterm.Success.Printfln("%q: Set env var %q to value in %q", item, strings.ToUpper(outputKey), item.SecretKey)
+	outputKey := item.OutputVariable
+
+	if err := out.Export(outputKey, val); err != nil {
+		log.Error("unable to export env variable", logKeyPhase, phaseExport, "variable", outputKey, logKeyError, err)
+		return fmt.Errorf("cannot set environment variable")
 	}
+	log.Info("exported variable", logKeyPhase, phaseExport, "variable", strings.ToUpper(outputKey), "key", item.SecretKey, logKeyStatus, statusSuccess)
 	return nil
 }
 
 func ParseRetrieve(retrieve string) ([]SecretToRetrieve, error) {
-	pterm.Info.Println("parseRetrieve()")
-
 	var retrieveThese []SecretToRetrieve
 	if err := json.Unmarshal([]byte(retrieve), &retrieveThese); err != nil {
 		return []SecretToRetrieve{}, fmt.Errorf("unable to unmarshal: %w", err)
 	}
-	pterm.Success.Printfln("parseRetrieve(): returning %+v", retrieveThese)
+	for i := range retrieveThese {
+		if err := retrieveThese[i].validate(); err != nil {
+			return []SecretToRetrieve{}, fmt.Errorf("entry %d: %w", i, err)
+		}
+	}
 	return retrieveThese, nil
 }
 
@@ -202,60 +540,38 @@ type HTTPClient interface {
 	Do(req *http.Request) (*http.Response, error)
 }
 
-func DSVGetToken(c HTTPClient, apiEndpoint string, cfg *Config) (string, error) {
-	pterm.Info.Println("DSVGetToken()")
-	body := []byte(fmt.Sprintf(
-		`{"grant_type":"client_credentials","client_id":"%s","client_secret":"%s"}`,
-		cfg.ClientIDEnv, cfg.ClientSecretEnv,
-	))
-	endpoint := apiEndpoint + "/token"
-	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
-	if err != nil {
-		return "", fmt.Errorf("could not build request: %w", err)
+// DSVGetToken requests an access token with the client credentials from cfg.
+func DSVGetToken(ctx context.Context, c HTTPClient, apiEndpoint string, cfg *Config) (string, error) {
+	cfg.log().Debug("DSVGetToken()")
+	token, err := cfg.newDSVClient(c, apiEndpoint).Token(ctx, cfg.ClientIDEnv, cfg.ClientSecretEnv)
+	if errors.Is(err, dsv.ErrNoAccessToken) {
+		return "", err
 	}
-
-	resp := make(map[string]interface{})
-	if err = cfg.sendRequest(c, req, &resp); err != nil {
+	if err != nil {
 		return "", fmt.Errorf("API call failed: %w", err)
 	}
-
-	token, ok := resp["accessToken"].(string)
-	if !ok {
-		return "", fmt.Errorf("could not read access token from response")
-	}
-	return token, nil
+	return token.AccessToken, nil
 }
 
-func DSVGetSecret(client HTTPClient, apiEndpoint, accessToken string, item SecretToRetrieve, cfg *Config) (map[string]interface{}, error) {
-	pterm.Info.Println("dsvGetSecret()")
-	// Endpoint := apiEndpoint + "/secrets/" + secretPath.
-	endpoint, err := url.JoinPath(apiEndpoint, "secrets", item.SecretPath)
-	if err != nil {
-		pterm.Debug.Println("dsvGetSecret() problem with building url")
-		return nil, fmt.Errorf("unable to build url: %w", err)
-	}
-	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
-	if err != nil {
-		pterm.Debug.Printfln("dsvGetSecret(): endpoint: %q", endpoint)
-		return nil, fmt.Errorf("could not build request: %w", err)
-	}
-
-	req.Header.Set("Authorization", accessToken)
+// DSVGetSecret reads the secret at item.SecretPath and returns the raw response.
+func DSVGetSecret(ctx context.Context, client HTTPClient, apiEndpoint, accessToken string, item SecretToRetrieve, cfg *Config) (map[string]interface{}, error) {
+	cfg.log().Debug("dsvGetSecret()")
+	c := cfg.newDSVClient(client, apiEndpoint)
+	c.Auth = dsv.StaticToken(accessToken)
 
 	resp := make(map[string]interface{})
-	if err = cfg.sendRequest(client, req, &resp); err != nil {
-		pterm.Debug.Printfln("cfg.sendRequest() failure on sending request endpoint:%q req:%+v", endpoint, req)
-
+	if err := c.Do(ctx, http.MethodGet, []string{"secrets", item.SecretPath}, nil, &resp); err != nil {
+		cfg.log().Debug("DSVGetSecret() failure on sending request", logKeyPath, item.SecretPath, logKeyError, err)
 		return nil, fmt.Errorf("API call failed: %w", err)
 	}
-	pterm.Success.Printfln("dsvGetSecret() success")
+	cfg.log().Debug("dsvGetSecret() success", logKeyStatus, statusSuccess)
 	return resp, nil
 }
 
 // OpenEnvFile storing secrets that can extend to another job or task in Gitlab.
 // See [GitLab - Passing An Environment Variable to Another Job](https://docs.gitlab.com/ee/ci/variables/#pass-an-environment-variable-to-another-job)
 func OpenEnvFile(cfg *Config) (envFile *os.File, err error) {
-	pterm.Info.Println("OpenEnvFile()")
+	cfg.log().Debug("OpenEnvFile()")
 	envFileName := cfg.getEnvFileName()
 	envFile, err = os.OpenFile(envFileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, PermissionReadWriteOwner) //nolint:nosnakecase // these are standard package values and ok to leave snakecase.
 	if errors.Is(err, os.ErrNotExist) {
@@ -265,15 +581,19 @@ func OpenEnvFile(cfg *Config) (envFile *os.File, err error) {
 	if err != nil {
 		return nil, fmt.Errorf("general error cannot open file %s: %w", envFileName, err)
 	}
-	pterm.Success.Printfln("OpenEnvFile() success")
+	cfg.log().Debug("OpenEnvFile() success", logKeyStatus, statusSuccess)
 	return envFile, nil
 }
 
+// ExportEnvVariable appends key, upper cased, and val to the dotenv file, quoting val when GitLab would
+// otherwise change it. Values with newlines and invalid names are rejected.
 func ExportEnvVariable(envFile *os.File, key, val string) error {
-	pterm.Info.Println("ExportEnvVariable()")
-	if _, err := envFile.WriteString(fmt.Sprintf("%s=%s\n", strings.ToUpper(key), val)); err != nil {
+	line, err := encodeDotEnv(strings.ToUpper(key), val)
+	if err != nil {
+		return err
+	}
+	if _, err := envFile.WriteString(line); err != nil {
 		return fmt.Errorf("could not update %s environment file: %w", envFile.Name(), err)
 	}
-	pterm.Success.Printfln("ExportEnvVariable() success")
 	return nil
 }
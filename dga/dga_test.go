@@ -1,27 +1,20 @@
 package dga_test
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/matryer/is"
 	"github.com/pterm/pterm"
 
 	dga "github.com/DelineaXPM/dsv-gitlab/dga"
+	"github.com/DelineaXPM/dsv-gitlab/dsv/dsvtest"
 )
 
-type MockHTTPClient struct {
-	response *http.Response
-	err      error
-}
-
-func (m *MockHTTPClient) Do(req *http.Request) (*http.Response, error) {
-	return m.response, m.err
-}
-
 func TestParseRetrieveFlag(t *testing.T) {
 	pterm.DisableOutput()
 	cases := []struct {
@@ -40,30 +33,45 @@ func TestParseRetrieveFlag(t *testing.T) {
 			name: "happy path",
 			retrieve: `
 			[
-				{"secretPath": "folder1/folder2/secret1", "secretKey": "mykey1", "outputVariable": ""},
-				{"secretPath": "folder1/folder2/secret1", "secretKey": "mykey2", "outputVariable": ""},
-				{"secretPath": "folder1/folder2/secret2", "secretKey": "key3", "outputVariable": ""}
+				{"secretPath": "folder1/folder2/secret1", "secretKey": "mykey1", "outputVariable": "MY_KEY_1"},
+				{"secretPath": "folder1/folder2/secret1", "secretKey": "mykey2", "outputVariable": "my_key_2"},
+				{"secretPath": "folder1/folder2/secret2", "secretKey": "key3", "outputVariable": "KEY3"}
 			]
 			`,
 			want: []dga.SecretToRetrieve{
 				{
 					SecretPath:     "folder1/folder2/secret1",
 					SecretKey:      "mykey1",
-					OutputVariable: "",
+					OutputVariable: "MY_KEY_1",
 				},
 				{
 					SecretPath:     "folder1/folder2/secret1",
 					SecretKey:      "mykey2",
-					OutputVariable: "",
+					OutputVariable: "my_key_2",
 				},
 				{
 					SecretPath:     "folder1/folder2/secret2",
 					SecretKey:      "key3",
-					OutputVariable: "",
+					OutputVariable: "KEY3",
 				},
 			},
 			wantErr: nil,
 		},
+		{
+			name:     "missing output variable",
+			retrieve: `[{"secretPath": "folder1/secret1", "secretKey": "key", "outputVariable": ""}]`,
+			wantErr:  errors.New("secret entries require outputVariable"),
+		},
+		{
+			name:     "invalid output variable",
+			retrieve: `[{"secretPath": "folder1/secret1", "secretKey": "key", "outputVariable": "db-password"}]`,
+			wantErr:  errors.New("invalid variable name"),
+		},
+		{
+			name:     "invalid output variable prefix",
+			retrieve: `[{"type": "aws", "secretPath": "aws:dev", "outputVariable": "prod env"}]`,
+			wantErr:  errors.New("invalid variable name"),
+		},
 		{
 			name: "invalid json input structure",
 			retrieve: `
@@ -96,227 +104,182 @@ func TestParseRetrieveFlag(t *testing.T) {
 
 func TestDsvGetToken(t *testing.T) {
 	pterm.DisableOutput()
-	is := is.New(t)
+	server := dsvtest.NewServer()
+	defer server.Close()
+	closed := dsvtest.NewServer()
+	closed.Close()
 
-	cfg := &dga.Config{
-		IsCI:            true,
-		ClientIDEnv:     "client_id",
-		ClientSecretEnv: "client_secret",
-	}
 	cases := []struct {
 		name        string
 		apiEndpoint string
-		cid         string
-		csecret     string
-		client      dga.HTTPClient
+		fault       *dsvtest.Fault
 		want        string
-		wantErr     error
+		wantErr     string // wantErr is the start of the error message.
 	}{
 		{
 			name:        "happy path",
-			apiEndpoint: "test.example.com",
-
-			client: &MockHTTPClient{
-				response: &http.Response{
-					Status:     "200 OK",
-					StatusCode: http.StatusOK,
-					Body: io.NopCloser(bytes.NewReader([]byte(`{
-						"accessToken": "token"
-					}`))),
-				},
-				err: nil,
-			},
-			want:    "token",
-			wantErr: nil,
+			apiEndpoint: server.BaseURL(),
+			want:        "access-",
 		},
 		{
 			name:        "bad request",
-			apiEndpoint: "test.example.com",
-
-			client: &MockHTTPClient{
-				response: &http.Response{
-					Status:     "400 Bad Request",
-					StatusCode: http.StatusBadRequest,
-					Body:       io.NopCloser(bytes.NewReader([]byte(nil))),
-				},
-				err: nil,
-			},
-			want:    "",
-			wantErr: fmt.Errorf("API call failed: POST test.example.com/token: 400 Bad Request"),
+			apiEndpoint: server.BaseURL(),
+			fault:       &dsvtest.Fault{Path: "/token", StatusCode: http.StatusBadRequest},
+			wantErr:     "API call failed: POST " + server.BaseURL() + "/token: 400 Bad Request",
 		},
 		{
 			name:        "empty endpoint",
 			apiEndpoint: "",
-
-			client: &MockHTTPClient{
-				response: &http.Response{
-					Status:     "400 Bad Request",
-					StatusCode: http.StatusBadRequest,
-					Body:       io.NopCloser(bytes.NewReader([]byte(nil))),
-				},
-				err: nil,
-			},
-			want:    "",
-			wantErr: fmt.Errorf("API call failed: POST /token: 400 Bad Request"),
+			wantErr:     "API call failed: ",
 		},
 		{
 			name:        "http error",
-			apiEndpoint: "test.example.com",
-
-			client: &MockHTTPClient{
-				response: nil,
-				err:      fmt.Errorf("error"),
-			},
-			want:    "",
-			wantErr: fmt.Errorf("API call failed: error"),
+			apiEndpoint: closed.BaseURL(),
+			wantErr:     "API call failed: ",
 		},
 		{
 			name:        "nil body",
-			apiEndpoint: "test.example.com",
-
-			client: &MockHTTPClient{
-				response: &http.Response{
-					Status:     "200 OK",
-					StatusCode: http.StatusOK,
-					Body:       io.NopCloser(bytes.NewReader([]byte(nil))),
-				},
-				err: nil,
-			},
-			want:    "",
-			wantErr: fmt.Errorf("API call failed: could not unmarshal response body: unexpected end of JSON input"),
+			apiEndpoint: server.BaseURL(),
+			fault:       &dsvtest.Fault{Path: "/token", StatusCode: http.StatusOK, Body: []byte{}},
+			wantErr:     "API call failed: could not unmarshal response body: unexpected end of JSON input",
 		},
 		{
 			name:        "no access token",
-			apiEndpoint: "test.example.com",
-
-			client: &MockHTTPClient{
-				response: &http.Response{
-					Status:     "200 OK",
-					StatusCode: http.StatusOK,
-					Body: io.NopCloser(bytes.NewReader([]byte(`{
-						"test": "token"
-					}`))),
-				},
-				err: nil,
-			},
-			want:    "",
-			wantErr: fmt.Errorf("could not read access token from response"),
+			apiEndpoint: server.BaseURL(),
+			fault:       &dsvtest.Fault{Path: "/token", StatusCode: http.StatusOK, Body: []byte(`{"test": "token"}`)},
+			wantErr:     "could not read access token from response",
 		},
 	}
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
-			result, err := dga.DSVGetToken(tc.client, tc.apiEndpoint, cfg)
+			is := is.New(t)
+			if tc.fault != nil {
+				tc.fault.Times = 1
+				server.Fail(*tc.fault)
+			}
+			cfg := &dga.Config{IsCI: true, ClientIDEnv: dsvtest.ClientID, ClientSecretEnv: dsvtest.ClientSecret}
 
-			if (tc.wantErr != nil && tc.wantErr.Error() != err.Error()) || (tc.wantErr == nil && err != nil) {
-				// T.Errorf("want error:\n\t%v\ngot:\n\t%v", tc.wantErr, err).
-				is.Equal(tc.wantErr, err) // Error should match.
+			result, err := dga.DSVGetToken(context.Background(), server.Server.Client(), tc.apiEndpoint, cfg)
+			if tc.wantErr != "" {
+				is.True(err != nil)                                 // Should fail.
+				is.True(strings.HasPrefix(err.Error(), tc.wantErr)) // Error should match.
+				is.Equal(result, "")                                // No token should be returned.
+				return
 			}
-			is.Equal(tc.want, result) // Result should match.
+			is.NoErr(err)
+			is.True(strings.HasPrefix(result, tc.want)) // Result should be the issued token.
 		})
 	}
 }
 
 func TestDsvGetSecret(t *testing.T) {
 	pterm.DisableOutput()
-	cfg := &dga.Config{
-		IsCI: true,
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.SetSecret("folder1/secret1", map[string]interface{}{"key": "val"})
+	closed := dsvtest.NewServer()
+	closed.Close()
+	cfg := &dga.Config{IsCI: true, ClientIDEnv: dsvtest.ClientID, ClientSecretEnv: dsvtest.ClientSecret}
+	token, err := dga.DSVGetToken(context.Background(), server.Server.Client(), server.BaseURL(), cfg)
+	if err != nil {
+		t.Fatal(err)
 	}
+	item := dga.SecretToRetrieve{SecretPath: "folder1/secret1", SecretKey: "key"}
+
 	cases := []struct {
-		name           string
-		client         dga.HTTPClient
-		apiEndpoint    string
-		accessToken    string
-		itemToRetrieve dga.SecretToRetrieve
-		want           map[string]interface{}
-		wantErr        bool
+		name        string
+		apiEndpoint string
+		fault       *dsvtest.Fault
+		want        map[string]interface{}
+		wantErr     bool
 	}{
 		{
-			name: "happy path",
-			client: &MockHTTPClient{
-				response: &http.Response{
-					Status:     "200 OK",
-					StatusCode: http.StatusOK,
-					Body: io.NopCloser(bytes.NewReader([]byte(`{
-						"key": "val"
-					}`))),
-				},
-				err: nil,
-			},
-			apiEndpoint: "test.example.com",
-			accessToken: "token",
-			itemToRetrieve: dga.SecretToRetrieve{
-				SecretPath: "folder1/secret1",
-				SecretKey:  "key",
-			},
-			want: map[string]interface{}{
-				"key": "val",
-			},
-			wantErr: false,
+			name:        "happy path",
+			apiEndpoint: server.BaseURL(),
+			want:        map[string]interface{}{"key": "val"},
 		},
 		{
-			name: "GET secret should fail with 400",
-			client: &MockHTTPClient{
-				response: &http.Response{
-					Status:     "400 Bad Request",
-					StatusCode: http.StatusBadRequest,
-					Body:       io.NopCloser(bytes.NewReader([]byte(nil))),
-				},
-				err: nil,
-			},
-			apiEndpoint: "test.example.com",
-			accessToken: "token",
-			itemToRetrieve: dga.SecretToRetrieve{
-				SecretPath: "folder1/secret1",
-				SecretKey:  "key",
-			},
-			want:    nil,
-			wantErr: true,
+			name:        "GET secret should fail with 400",
+			apiEndpoint: server.BaseURL(),
+			fault:       &dsvtest.Fault{Path: "/secrets/folder1/secret1", StatusCode: http.StatusBadRequest},
+			wantErr:     true,
 		},
 		{
-			name: "GET should fail",
-			client: &MockHTTPClient{
-				response: nil,
-				err:      fmt.Errorf("error"),
-			},
-			apiEndpoint: "test.example.com",
-			accessToken: "token",
-			itemToRetrieve: dga.SecretToRetrieve{
-				SecretPath: "folder1/secret1",
-				SecretKey:  "key",
-			},
-			want:    nil,
-			wantErr: true,
+			name:        "GET should fail",
+			apiEndpoint: closed.BaseURL(),
+			wantErr:     true,
 		},
 		{
-			name: "nil body should fail on unmarshaling",
-			client: &MockHTTPClient{
-				response: &http.Response{
-					Status:     "200 OK",
-					StatusCode: http.StatusOK,
-					Body:       io.NopCloser(bytes.NewReader([]byte(nil))),
-				},
-				err: nil,
-			},
-			apiEndpoint: "test.example.com",
-			accessToken: "token",
-			itemToRetrieve: dga.SecretToRetrieve{
-				SecretPath: "folder1/secret1",
-				SecretKey:  "key",
-			},
-			want:    nil,
-			wantErr: true,
+			name:        "nil body should fail on unmarshaling",
+			apiEndpoint: server.BaseURL(),
+			fault:       &dsvtest.Fault{Path: "/secrets/folder1/secret1", StatusCode: http.StatusOK, Body: []byte{}},
+			wantErr:     true,
 		},
 	}
-
 	for _, tc := range cases {
-		is := is.New(t)
 		t.Run(tc.name, func(t *testing.T) {
-			result, err := dga.DSVGetSecret(tc.client, tc.apiEndpoint, tc.accessToken, tc.itemToRetrieve, cfg)
+			is := is.New(t)
+			if tc.fault != nil {
+				tc.fault.Times = 1
+				server.Fail(*tc.fault)
+			}
+			result, err := dga.DSVGetSecret(context.Background(), server.Server.Client(), tc.apiEndpoint, token, item, cfg)
 			if tc.wantErr {
-				is.True(err != nil) // Should fail due to file missing.
-			} else {
-				is.Equal(tc.want, result) // Returned result should match expected value.
+				is.True(err != nil) // Should fail.
+				return
 			}
+			is.NoErr(err)
+			is.Equal(result["data"], tc.want) // Returned data should match expected value.
 		})
 	}
 }
+
+func TestDsvGetTokenServer(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+	server := dsvtest.NewServer()
+	defer server.Close()
+
+	cfg := &dga.Config{ClientIDEnv: dsvtest.ClientID, ClientSecretEnv: dsvtest.ClientSecret}
+	token, err := dga.DSVGetToken(context.Background(), server.Server.Client(), server.BaseURL(), cfg)
+	is.NoErr(err)
+	is.True(token != "") // Should return the issued token.
+
+	requests := server.Requests()
+	is.Equal(len(requests), 1)
+	is.Equal(requests[0].Method, http.MethodPost)
+	is.Equal(requests[0].Path, "/token")
+	is.Equal(requests[0].Header.Get("Content-Type"), "application/json")
+	is.Equal(requests[0].Header.Get("Delinea-DSV-Client"), "gitlab-action")
+
+	cfg.ClientSecretEnv = "wrong"
+	_, err = dga.DSVGetToken(context.Background(), server.Server.Client(), server.BaseURL(), cfg)
+	is.True(err != nil) // Wrong credentials should be rejected.
+}
+
+func TestDsvGetSecretServer(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.SetSecret("folder1:secret 1", map[string]interface{}{"key": "val"})
+	cfg := &dga.Config{ClientIDEnv: dsvtest.ClientID, ClientSecretEnv: dsvtest.ClientSecret}
+	token, err := dga.DSVGetToken(context.Background(), server.Server.Client(), server.BaseURL(), cfg)
+	is.NoErr(err)
+
+	item := dga.SecretToRetrieve{SecretPath: "folder1:secret 1", SecretKey: "key"}
+	result, err := dga.DSVGetSecret(context.Background(), server.Server.Client(), server.BaseURL(), token, item, cfg)
+	is.NoErr(err)
+	is.Equal(result["data"], map[string]interface{}{"key": "val"})
+
+	last := server.Requests()[len(server.Requests())-1]
+	is.Equal(last.Method, http.MethodGet)
+	is.Equal(last.Path, "/secrets/folder1:secret 1") // The path should be escaped and decoded back.
+	is.Equal(last.Header.Get("Authorization"), token)
+
+	_, err = dga.DSVGetSecret(context.Background(), server.Server.Client(), server.BaseURL(), "expired", item, cfg)
+	is.True(err != nil) // Unknown tokens should be rejected.
+	item.SecretPath = "folder1:missing"
+	_, err = dga.DSVGetSecret(context.Background(), server.Server.Client(), server.BaseURL(), token, item, cfg)
+	is.True(err != nil) // Missing secrets should fail.
+}
@@ -0,0 +1,120 @@
+package dga
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+
+	"github.com/DelineaXPM/dsv-gitlab/dsv"
+)
+
+const (
+	// EntryTypeDocker adds registry credentials from a secret to a Docker config.json.
+	EntryTypeDocker = "docker"
+
+	dockerConfigFileName = "config.json"
+	// dockerHubRegistry is the key Docker uses for Docker Hub credentials.
+	dockerHubRegistry = "https://index.docker.io/v1/"
+
+	defaultUsernameKey = "username"
+	defaultPasswordKey = "password"
+)
+
+// dockerAuth is an entry of the auths section of a Docker config.json.
+type dockerAuth struct {
+	Auth string `json:"auth"`
+}
+
+// dockerAuths collects the registry credentials of docker entries, written once every entry has been processed.
+type dockerAuths struct {
+	auths map[string]dockerAuth
+}
+
+// validateDocker checks the fields of a docker entry.
+func (s *SecretToRetrieve) validateDocker() error {
+	if s.SecretPath == "" || s.Registry == "" {
+		return errors.New("docker entries require secretPath and registry")
+	}
+	return nil
+}
+
+// dockerRegistryKey returns the auths key for registry, the one Docker uses for Docker Hub.
+func dockerRegistryKey(registry string) string {
+	switch strings.TrimSuffix(strings.TrimPrefix(registry, "https://"), "/") {
+	case "docker.io", "index.docker.io", "registry-1.docker.io", "index.docker.io/v1":
+		return dockerHubRegistry
+	}
+	return registry
+}
+
+// addDockerAuth reads the username and password for item.Registry from the secret at item.SecretPath.
+func (cfg *Config) addDockerAuth(ctx context.Context, client *dsv.Client, auths *dockerAuths, item SecretToRetrieve) error {
+	log := cfg.log().With(logKeyPhase, phaseRetrieve, logKeyPath, item.SecretPath)
+	log.Debug("start processing", "registry", item.Registry)
+	registry := dockerRegistryKey(item.Registry)
+	if _, ok := auths.auths[registry]; ok {
+		log.Error("registry is used by more than one entry", "registry", item.Registry)
+		return fmt.Errorf("duplicate docker registry")
+	}
+	secret, err := client.GetSecret(ctx, item.SecretPath)
+	if err != nil {
+		log.Error("failed to fetch secret", logKeyError, err)
+		return requestError(err, "unable to get secret")
+	}
+
+	credentials := [2]string{}
+	for i, key := range []string{stringOr(item.UsernameKey, defaultUsernameKey), stringOr(item.PasswordKey, defaultPasswordKey)} {
+		val, ok := secret.Data[key].(string)
+		if !ok || val == "" {
+			log.Error("key not found in data", "key", key)
+			return notFoundError("specified field was not found in data")
+		}
+		credentials[i] = val
+	}
+	if auths.auths == nil {
+		auths.auths = map[string]dockerAuth{}
+	}
+	auths.auths[registry] = dockerAuth{Auth: base64.StdEncoding.EncodeToString([]byte(credentials[0] + ":" + credentials[1]))}
+	log.Info("added credentials for registry", "registry", item.Registry, logKeyStatus, statusSuccess)
+	return nil
+}
+
+// write writes config.json to dir and exports DOCKER_CONFIG for docker, crane and kaniko, and
+// REGISTRY_AUTH_FILE for buildah, podman and skopeo. It does nothing when there are no registries.
+func (a *dockerAuths) write(log *slog.Logger, out exporter, dir string) error {
+	if len(a.auths) == 0 {
+		return nil
+	}
+	config, err := json.MarshalIndent(struct {
+		Auths map[string]dockerAuth `json:"auths"`
+	}{a.auths}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to encode docker config: %w", err)
+	}
+	path := filepath.Join(dir, dockerConfigFileName)
+	if err := out.WriteFile(path, append(config, '\n'), PermissionReadWriteOwner); err != nil {
+		log.Error("unable to write docker config", logKeyPhase, phaseWrite, logKeyError, err)
+		return fmt.Errorf("cannot write docker config")
+	}
+	for _, kv := range [][2]string{{"DOCKER_CONFIG", dir}, {"REGISTRY_AUTH_FILE", path}} {
+		if err := out.Export(kv[0], kv[1]); err != nil {
+			log.Error("unable to export env variable", logKeyPhase, phaseExport, "variable", kv[0], logKeyError, err)
+			return fmt.Errorf("cannot set environment variable")
+		}
+	}
+	log.Info("wrote docker credentials", logKeyPhase, phaseWrite, "registries", len(a.auths), "file", path, logKeyStatus, statusSuccess)
+	return nil
+}
+
+// stringOr returns s, or def when s is empty.
+func stringOr(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
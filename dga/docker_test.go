@@ -0,0 +1,79 @@
+package dga_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/pterm/pterm"
+
+	dga "github.com/DelineaXPM/dsv-gitlab/dga"
+	"github.com/DelineaXPM/dsv-gitlab/dsv/dsvtest"
+)
+
+func TestRunDockerConfig(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.SetSecret("ci:registry:hub", map[string]interface{}{"username": "hubuser", "password": "hubpass"})
+	server.SetSecret("ci:registry:gitlab", map[string]interface{}{"user": "deploy-token", "token": "glpat"})
+	opts := hermeticRun(t, server, map[string]string{"DSV_RETRIEVE": `[
+		{"type": "docker", "secretPath": "ci:registry:hub", "registry": "docker.io"},
+		{"type": "docker", "secretPath": "ci:registry:gitlab", "registry": "registry.gitlab.com", "usernameKey": "user", "passwordKey": "token"}
+	]`})
+
+	is.NoErr(dga.Run(context.Background(), opts))
+
+	dir := filepath.Join(opts.Root, ".docker")
+	vars := readDotEnv(t, opts)
+	is.Equal(vars, map[string]string{
+		"DOCKER_CONFIG":      dir,
+		"REGISTRY_AUTH_FILE": filepath.Join(dir, "config.json"),
+	})
+
+	b, err := os.ReadFile(vars["REGISTRY_AUTH_FILE"])
+	is.NoErr(err)
+	config := map[string]map[string]map[string]string{}
+	is.NoErr(json.Unmarshal(b, &config))
+	is.Equal(config, map[string]map[string]map[string]string{
+		"auths": {
+			"https://index.docker.io/v1/": {"auth": "aHVidXNlcjpodWJwYXNz"},     // hubuser:hubpass
+			"registry.gitlab.com":         {"auth": "ZGVwbG95LXRva2VuOmdscGF0"}, // deploy-token:glpat
+		},
+	})
+	info, err := os.Stat(vars["REGISTRY_AUTH_FILE"])
+	is.NoErr(err)
+	is.Equal(info.Mode().Perm(), os.FileMode(0o600)) // Config should only be readable by the owner.
+}
+
+func TestRunDockerConfigErrors(t *testing.T) {
+	pterm.DisableOutput()
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.SetSecret("ci:registry", map[string]interface{}{"username": "user", "password": "pass"})
+	cases := []struct {
+		name     string
+		retrieve string
+	}{
+		{name: "missing password", retrieve: `[{"type": "docker", "secretPath": "ci:registry", "registry": "ghcr.io", "passwordKey": "token"}]`},
+		{name: "duplicate registry", retrieve: `[
+			{"type": "docker", "secretPath": "ci:registry", "registry": "index.docker.io"},
+			{"type": "docker", "secretPath": "ci:registry", "registry": "docker.io"}
+		]`},
+		{name: "missing registry", retrieve: `[{"type": "docker", "secretPath": "ci:registry"}]`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			opts := hermeticRun(t, server, map[string]string{"DSV_RETRIEVE": tc.retrieve})
+
+			is.True(dga.Run(context.Background(), opts) != nil) // Should fail.
+			_, err := os.Stat(filepath.Join(opts.Root, ".docker", "config.json"))
+			is.True(os.IsNotExist(err)) // No config should be written.
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package dga
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/DelineaXPM/dsv-gitlab/dsv"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Docker credential helper operations, passed by docker as the first argument.
+const (
+	DockerCredentialGet   = "get"
+	DockerCredentialList  = "list"
+	DockerCredentialStore = "store"
+	DockerCredentialErase = "erase"
+)
+
+// ErrCredentialsNotFound is the error docker expects on stdout when a helper has no credentials for a
+// registry, after which it continues without them.
+var ErrCredentialsNotFound = errors.New("credentials not found in native keychain")
+
+// DockerCredentialMapping names the DSV secret holding the credentials of a registry.
+//
+//nolint:tagliatelle // Here 'camel' casing is used to match DSV_RETRIEVE.
+type DockerCredentialMapping struct {
+	SecretPath  string `yaml:"secretPath"`  // SecretPath is the secret holding the credentials.
+	Username    string `yaml:"username"`    // Username is the user name, read from the secret when empty.
+	UsernameKey string `yaml:"usernameKey"` // UsernameKey is the data key holding the user name, username by default.
+	PasswordKey string `yaml:"passwordKey"` // PasswordKey is the data key holding the password or token, password by default.
+}
+
+// dockerCredentials is the answer to a get request.
+//
+//nolint:tagliatelle // Here the docker credential helper field names are used.
+type dockerCredentials struct {
+	ServerURL string `json:"ServerURL"`
+	Username  string `json:"Username"`
+	Secret    string `json:"Secret"`
+}
+
+// ParseDockerCredentials parses a JSON or YAML document mapping registries to secrets. Registries
+// are keyed by host, and scheme, path and the Docker Hub aliases are ignored when looking them up.
+func ParseDockerCredentials(b []byte) (map[string]DockerCredentialMapping, error) {
+	mappings := map[string]DockerCredentialMapping{}
+	if err := yaml.Unmarshal(b, &mappings); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal docker credential mappings: %w", err)
+	}
+	hosts := map[string]string{}
+	for registry, m := range mappings {
+		if m.SecretPath == "" {
+			return nil, fmt.Errorf("registry %q: secretPath is required", registry)
+		}
+		if m.Username != "" && m.UsernameKey != "" {
+			return nil, fmt.Errorf("registry %q: username and usernameKey cannot both be set", registry)
+		}
+		host := registryHost(registry)
+		if other, ok := hosts[host]; ok {
+			return nil, fmt.Errorf("registries %q and %q are the same registry", other, registry)
+		}
+		hosts[host] = registry
+	}
+	return mappings, nil
+}
+
+// registryHost returns the host of a registry name or server URL, with docker.io for Docker Hub.
+func registryHost(registry string) string {
+	host := strings.ToLower(strings.TrimSpace(registry))
+	if _, rest, ok := strings.Cut(host, "://"); ok {
+		host = rest
+	}
+	host, _, _ = strings.Cut(host, "/")
+	if dockerRegistryKey(host) == dockerHubRegistry {
+		return "docker.io"
+	}
+	return host
+}
+
+// dockerCredentialsFile returns the mapping file, DSV_DOCKER_CREDENTIALS_FILE or else
+// dsv-credentials.yaml next to the Docker config. DOCKER_CONFIG and HOME are read from the
+// environment the configuration was parsed from, and relative paths are resolved like other local files.
+func (cfg *Config) dockerCredentialsFile(opts *Options) (string, error) {
+	if cfg.DockerCredentialsFile != "" {
+		return opts.rootPath(cfg.DockerCredentialsFile), nil
+	}
+	if dir := cfg.environment["DOCKER_CONFIG"]; dir != "" {
+		return opts.rootPath(filepath.Join(dir, "dsv-credentials.yaml")), nil
+	}
+	home := cfg.environment["HOME"]
+	if home == "" {
+		return "", errors.New("unable to find docker credentials file, set DSV_DOCKER_CREDENTIALS_FILE, DOCKER_CONFIG or HOME")
+	}
+	return filepath.Join(home, ".docker", "dsv-credentials.yaml"), nil
+}
+
+// DockerCredential implements the read-only part of the docker credential helper protocol for
+// operation. get reads a registry server URL from in and writes its credentials to out as JSON,
+// returning ErrCredentialsNotFound when the registry is not mapped. list writes every mapped registry with
+// the username of its mapping, empty when not set, and never reads DSV. store and erase fail, credentials
+// are managed in DSV.
+func DockerCredential(ctx context.Context, opts Options, operation string, in io.Reader, out io.Writer) error {
+	switch operation {
+	case DockerCredentialGet, DockerCredentialList:
+	case DockerCredentialStore, DockerCredentialErase:
+		return configError(fmt.Errorf("docker credential %s is not supported, credentials are read-only and managed in DSV", operation))
+	default:
+		return configError(fmt.Errorf("unsupported docker credential operation %q, expected %s or %s", operation, DockerCredentialGet, DockerCredentialList))
+	}
+
+	opts.helper = true
+	cfg, err := parseConfig(opts)
+	if err != nil {
+		return configError(err)
+	}
+	cfg.logDebug()
+	path, err := cfg.dockerCredentialsFile(&opts)
+	if err != nil {
+		return configError(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return configError(fmt.Errorf("unable to read docker credentials file: %w", err))
+	}
+	mappings, err := ParseDockerCredentials(b)
+	if err != nil {
+		return configError(err)
+	}
+
+	if operation == DockerCredentialList {
+		// The list is answered from the mapping file alone, so listing never reads a password.
+		list := map[string]string{}
+		for registry, m := range mappings {
+			list[dockerRegistryKey(registry)] = m.Username
+		}
+		if err := json.NewEncoder(out).Encode(list); err != nil {
+			return fmt.Errorf("unable to write docker credentials: %w", err)
+		}
+		cfg.log().Info("listed docker registries", logKeyPhase, phaseHelper, "registries", len(list), logKeyStatus, statusSuccess)
+		return nil
+	}
+
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("unable to read server URL: %w", err)
+	}
+	serverURL := strings.TrimSpace(line)
+	registry, ok := "", false
+	for r := range mappings {
+		if registryHost(r) == registryHost(serverURL) {
+			registry, ok = r, true
+		}
+	}
+	if serverURL == "" || !ok {
+		cfg.log().Debug("no docker credential mapping", logKeyPhase, phaseHelper, "registry", serverURL)
+		return ErrCredentialsNotFound
+	}
+
+	ctx, cancel := cfg.withTimeout(ctx)
+	defer cancel()
+	client, err := cfg.connect(ctx)
+	if err != nil {
+		return err
+	}
+	username, password, err := readDockerCredentials(ctx, cfg.log(), client, mappings[registry])
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(out).Encode(dockerCredentials{ServerURL: serverURL, Username: username, Secret: password}); err != nil {
+		return fmt.Errorf("unable to write docker credentials: %w", err)
+	}
+	cfg.log().Info("provided docker credentials", logKeyPhase, phaseHelper, "registry", registry, logKeyStatus, statusSuccess)
+	return nil
+}
+
+// readDockerCredentials reads the user name and password of a registry from its secret, or only
+// the password when the mapping names the user.
+func readDockerCredentials(ctx context.Context, log *slog.Logger, client *dsv.Client, m DockerCredentialMapping) (string, string, error) {
+	secret, err := client.GetSecret(ctx, m.SecretPath)
+	if err != nil {
+		log.Error("failed to fetch secret", logKeyPhase, phaseHelper, logKeyPath, m.SecretPath, logKeyError, err)
+		return "", "", requestError(err, "unable to get secret")
+	}
+	credentials := [2]string{m.Username}
+	for i, key := range []string{stringOr(m.UsernameKey, defaultUsernameKey), stringOr(m.PasswordKey, defaultPasswordKey)} {
+		if credentials[i] != "" {
+			continue
+		}
+		val, ok := secret.Data[key].(string)
+		if !ok || val == "" {
+			log.Error("key not found in data", logKeyPhase, phaseHelper, logKeyPath, m.SecretPath, "key", key)
+			return "", "", notFoundError("specified field was not found in data")
+		}
+		credentials[i] = val
+	}
+	return credentials[0], credentials[1], nil
+}
@@ -0,0 +1,166 @@
+package dga_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/pterm/pterm"
+
+	dga "github.com/DelineaXPM/dsv-gitlab/dga"
+	"github.com/DelineaXPM/dsv-gitlab/dsv/dsvtest"
+)
+
+// dockerHelper returns Options for the docker credential helper against server, with mappings
+// written to the DSV_DOCKER_CREDENTIALS_FILE below the root.
+func dockerHelper(t *testing.T, server *dsvtest.Server, mappings string) dga.Options {
+	t.Helper()
+	opts := hermeticRun(t, server, map[string]string{"DSV_DOCKER_CREDENTIALS_FILE": "dsv-credentials.yaml"})
+	if err := os.WriteFile(filepath.Join(opts.Root, "dsv-credentials.yaml"), []byte(mappings), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return opts
+}
+
+func TestDockerCredential(t *testing.T) {
+	pterm.DisableOutput()
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.SetSecret("ci:registry:hub", map[string]interface{}{"username": "hubuser", "password": "hubpass"})
+	server.SetSecret("ci:registry:gitlab", map[string]interface{}{"token": "glpat"})
+	server.SetSecret("ci:registry:ghcr", map[string]interface{}{"user": "octocat", "token": "ghp"})
+	opts := dockerHelper(t, server, `
+docker.io:
+  secretPath: ci:registry:hub
+registry.gitlab.com:
+  secretPath: ci:registry:gitlab
+  username: deploy-token
+  passwordKey: token
+ghcr.io:
+  secretPath: ci:registry:ghcr
+  usernameKey: user
+  passwordKey: token
+`)
+
+	cases := []struct {
+		name      string
+		serverURL string
+		want      map[string]string
+	}{
+		{name: "hub", serverURL: "https://index.docker.io/v1/\n", want: map[string]string{"ServerURL": "https://index.docker.io/v1/", "Username": "hubuser", "Secret": "hubpass"}},
+		{name: "host", serverURL: "registry.gitlab.com", want: map[string]string{"ServerURL": "registry.gitlab.com", "Username": "deploy-token", "Secret": "glpat"}},
+		{name: "url", serverURL: "https://Registry.GitLab.com/v2/", want: map[string]string{"ServerURL": "https://Registry.GitLab.com/v2/", "Username": "deploy-token", "Secret": "glpat"}},
+		{name: "username key", serverURL: "ghcr.io", want: map[string]string{"ServerURL": "ghcr.io", "Username": "octocat", "Secret": "ghp"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			out := &bytes.Buffer{}
+			is.NoErr(dga.DockerCredential(context.Background(), opts, "get", strings.NewReader(tc.serverURL), out))
+			got := map[string]string{}
+			is.NoErr(json.Unmarshal(out.Bytes(), &got))
+			is.Equal(got, tc.want)
+		})
+	}
+
+	t.Run("list", func(t *testing.T) {
+		is := is.New(t)
+		requests := len(server.Requests())
+		out := &bytes.Buffer{}
+		is.NoErr(dga.DockerCredential(context.Background(), opts, "list", strings.NewReader(""), out))
+		got := map[string]string{}
+		is.NoErr(json.Unmarshal(out.Bytes(), &got))
+		// Only user names given in the mapping file are listed, secrets are never read.
+		is.Equal(got, map[string]string{"https://index.docker.io/v1/": "", "registry.gitlab.com": "deploy-token", "ghcr.io": ""})
+		is.Equal(len(server.Requests()), requests)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		is := is.New(t)
+		out := &bytes.Buffer{}
+		err := dga.DockerCredential(context.Background(), opts, "get", strings.NewReader("quay.io\n"), out)
+		is.True(errors.Is(err, dga.ErrCredentialsNotFound)) // Docker should continue without credentials.
+		is.Equal(out.String(), "")
+	})
+}
+
+func TestDockerCredentialErrors(t *testing.T) {
+	pterm.DisableOutput()
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.SetSecret("ci:registry", map[string]interface{}{"username": "user"})
+	cases := []struct {
+		name      string
+		operation string
+		mappings  string
+	}{
+		{name: "store", operation: "store", mappings: `ghcr.io: {secretPath: ci:registry}`},
+		{name: "erase", operation: "erase", mappings: `ghcr.io: {secretPath: ci:registry}`},
+		{name: "missing password", operation: "get", mappings: `ghcr.io: {secretPath: ci:registry}`},
+		{name: "missing secretPath", operation: "get", mappings: `ghcr.io: {usernameKey: user}`},
+		{name: "username and usernameKey", operation: "list", mappings: `ghcr.io: {secretPath: ci:registry, username: user, usernameKey: user}`},
+		{name: "same registry", operation: "get", mappings: "docker.io: {secretPath: a}\nindex.docker.io: {secretPath: b}"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			opts := dockerHelper(t, server, tc.mappings)
+			out := &bytes.Buffer{}
+			err := dga.DockerCredential(context.Background(), opts, tc.operation, strings.NewReader("ghcr.io\n"), out)
+			is.True(err != nil)                                  // Should fail.
+			is.True(!errors.Is(err, dga.ErrCredentialsNotFound)) // Failures should not look like a missing registry.
+			is.Equal(out.String(), "")
+		})
+	}
+}
+
+func TestDockerCredentialLocalSources(t *testing.T) {
+	pterm.DisableOutput()
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.SetSecret("ci:registry:hub", map[string]interface{}{"username": "hubuser", "password": "hubpass"})
+	other := dsvtest.NewServer()
+	defer other.Close()
+	mappings := []byte("docker.io:\n  secretPath: ci:registry:hub\n")
+
+	cases := []struct {
+		name    string
+		env     map[string]string
+		dir     string // dir receives dsv-credentials.yaml, relative to the root.
+		dotenv  string
+		wantErr bool
+	}{
+		{name: "docker config", env: map[string]string{"DOCKER_CONFIG": "docker"}, dir: "docker"},
+		{name: "home", env: map[string]string{"HOME": "home"}, dir: filepath.Join("home", ".docker")},
+		{name: "default env file ignored", env: map[string]string{"DSV_API_URL": "", "DOCKER_CONFIG": "docker"}, dir: "docker", dotenv: "DSV_API_URL=" + other.BaseURL() + "\n", wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			opts := hermeticRun(t, server, tc.env)
+			opts.Local = true
+			if home := opts.Environment["HOME"]; home != "" {
+				opts.Environment["HOME"] = filepath.Join(opts.Root, home)
+			}
+			is.NoErr(os.MkdirAll(filepath.Join(opts.Root, tc.dir), 0o700))
+			is.NoErr(os.WriteFile(filepath.Join(opts.Root, tc.dir, "dsv-credentials.yaml"), mappings, 0o600))
+			is.NoErr(os.WriteFile(filepath.Join(opts.Root, ".env"), []byte(tc.dotenv), 0o600))
+
+			out := &bytes.Buffer{}
+			err := dga.DockerCredential(context.Background(), opts, dga.DockerCredentialGet, strings.NewReader("docker.io"), out)
+			is.Equal(len(other.Requests()), 0) // The client secret should never be sent to the .env server.
+			if tc.wantErr {
+				is.Equal(dga.KindOf(err), dga.ErrorConfig)
+				return
+			}
+			is.NoErr(err)
+			is.True(strings.Contains(out.String(), `"Secret":"hubpass"`))
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package dga
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrUnencodableValue is returned for values that cannot be written to a dotenv file, which holds one variable per line.
+var ErrUnencodableValue = errors.New("value contains a newline, which dotenv files cannot hold; write it to a file instead")
+
+// dotEnvKey is the variable name format GitLab accepts in dotenv reports.
+var dotEnvKey = regexp.MustCompile(`\A[a-zA-Z0-9_]+\z`)
+
+// dotEnvSpace is what GitLab strips from both ends of a dotenv value.
+const dotEnvSpace = " \t\n\v\f\r\x00"
+
+// encodeDotEnv returns the dotenv line for key and val as GitLab reads dotenv reports: the line is
+// split at the first =, both sides are stripped of surrounding whitespace and one pair of matching
+// surrounding quotes is removed from the value. Values that would be changed by that are wrapped in
+// double quotes, everything else is written as is.
+func encodeDotEnv(key, val string) (string, error) {
+	if err := checkVariableName(key); err != nil {
+		return "", err
+	}
+	if strings.ContainsRune(val, '\n') {
+		return "", fmt.Errorf("%s: %w", key, ErrUnencodableValue)
+	}
+	if needsDotEnvQuotes(val) {
+		val = `"` + val + `"`
+	}
+	return key + "=" + val + "\n", nil
+}
+
+// checkVariableName returns an error when name cannot be written to a dotenv file.
+func checkVariableName(name string) error {
+	if !dotEnvKey.MatchString(name) {
+		return fmt.Errorf("invalid variable name %q, only letters, digits and _ are allowed", name)
+	}
+	return nil
+}
+
+// needsDotEnvQuotes reports whether GitLab would strip whitespace or quotes from val.
+func needsDotEnvQuotes(val string) bool {
+	if val == "" {
+		return false
+	}
+	first, last := val[0], val[len(val)-1]
+	if strings.IndexByte(dotEnvSpace, first) >= 0 || strings.IndexByte(dotEnvSpace, last) >= 0 {
+		return true
+	}
+	return (first == '"' && last == '"') || (first == '\'' && last == '\'')
+}
@@ -0,0 +1,214 @@
+package dga
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DelineaXPM/dsv-gitlab/dsv"
+)
+
+const (
+	// EntryTypeAWS exports AWS credentials from a DSV dynamic secret.
+	EntryTypeAWS = "aws"
+	// EntryTypeAzure exports Azure service principal credentials from a DSV dynamic secret.
+	EntryTypeAzure = "azure"
+	// EntryTypeGCP writes a GCP service account key from a DSV dynamic secret.
+	EntryTypeGCP = "gcp"
+
+	// gcpCredentialsFileName is the service account key file written to outputDir.
+	gcpCredentialsFileName = "gcp-credentials.json"
+	// gcpKeyDataKey is the dynamic secret data key holding the service account key.
+	gcpKeyDataKey = "serviceAccountKey"
+	// expirationDataKey is the dynamic secret data key holding when the credentials expire.
+	expirationDataKey = "expiration"
+)
+
+// cloudVariable maps a dynamic secret data key to the variable name the cloud's tools read.
+type cloudVariable struct {
+	name     string
+	key      string
+	required bool
+}
+
+// cloudVariables are the variables exported for the aws and azure entry types.
+//
+//nolint:gochecknoglobals // lookup table.
+var cloudVariables = map[string][]cloudVariable{
+	EntryTypeAWS: {
+		{name: "AWS_ACCESS_KEY_ID", key: "accessKeyId", required: true},
+		{name: "AWS_SECRET_ACCESS_KEY", key: "secretAccessKey", required: true},
+		{name: "AWS_SESSION_TOKEN", key: "sessionToken"},
+	},
+	EntryTypeAzure: {
+		{name: "AZURE_CLIENT_ID", key: "clientId", required: true},
+		{name: "AZURE_CLIENT_SECRET", key: "clientSecret", required: true},
+		{name: "AZURE_TENANT_ID", key: "tenantId"},
+		{name: "AZURE_SUBSCRIPTION_ID", key: "subscriptionId"},
+	},
+}
+
+// expiryVariables are the variables holding when the credentials of each entry type expire.
+//
+//nolint:gochecknoglobals // lookup table.
+var expiryVariables = map[string]string{
+	EntryTypeAWS:   "AWS_CREDENTIAL_EXPIRATION",
+	EntryTypeAzure: "AZURE_CREDENTIAL_EXPIRATION",
+	EntryTypeGCP:   "GOOGLE_CREDENTIAL_EXPIRATION",
+}
+
+// validateDynamic checks the fields of an aws, azure or gcp entry.
+func (s *SecretToRetrieve) validateDynamic() error {
+	if s.SecretPath == "" {
+		return fmt.Errorf("%s entries require secretPath", s.Type)
+	}
+	if s.Type == EntryTypeGCP && s.OutputDir == "" {
+		return errors.New("gcp entries require outputDir")
+	}
+	if _, err := parseTTL(s.TTL); err != nil {
+		return err
+	}
+	return nil
+}
+
+// parseTTL parses a duration such as 90s, 15m or 12h, also accepting days such as 7d.
+// An empty ttl is zero.
+func parseTTL(ttl string) (time.Duration, error) {
+	if ttl == "" {
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(ttl, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n > 0 {
+			return time.Duration(n) * 24 * time.Hour, nil
+		}
+	}
+	d, err := time.ParseDuration(ttl)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid ttl %q, expected a duration such as 15m, 12h or 7d", ttl)
+	}
+	return d, nil
+}
+
+// exportCloudCredentials reads the dynamic secret at item.SecretPath, which issues new credentials,
+// and exports them under the variable names the cloud's CLIs and SDKs read, prefixed with
+// outputVariable and an underscore when it is set. GCP service account keys are written to a file
+// in outputDir exported as GOOGLE_APPLICATION_CREDENTIALS. The expiry is exported in RFC 3339
+// format when DSV returns it or a ttl is set. AWS credentials of entries with a profile are added
+// to profiles instead.
+func (cfg *Config) exportCloudCredentials(ctx context.Context, client *dsv.Client, out exporter, profiles *awsProfiles, item SecretToRetrieve) error {
+	log := cfg.log().With(logKeyPhase, phaseRetrieve, logKeyPath, item.SecretPath)
+	log.Debug("start processing", "type", item.Type, "ttl", item.TTL)
+	ttl, err := parseTTL(item.TTL)
+	if err != nil {
+		return err
+	}
+	requestedAt := time.Now()
+	secret, err := client.GetDynamicSecret(ctx, item.SecretPath, ttl)
+	if err != nil {
+		log.Error("unable to get credentials", "type", item.Type, logKeyError, err)
+		return requestError(err, "unable to get dynamic secret")
+	}
+
+	var exports [][2]string
+	if item.Type == EntryTypeGCP {
+		key, err := gcpServiceAccountKey(secret.Data[gcpKeyDataKey])
+		if err != nil {
+			log.Error("invalid credentials", logKeyError, err)
+			return notFoundError("specified field was not found in data")
+		}
+		path := filepath.Join(cfg.outputPath(item.OutputDir), gcpCredentialsFileName)
+		if err := out.WriteFile(path, key, PermissionReadWriteOwner); err != nil {
+			log.Error("invalid credentials", logKeyError, err)
+			return fmt.Errorf("cannot write credentials file")
+		}
+		exports = append(exports, [2]string{"GOOGLE_APPLICATION_CREDENTIALS", path})
+	}
+	for _, v := range cloudVariables[item.Type] {
+		val, _ := secret.Data[v.key].(string)
+		if val == "" {
+			if v.required {
+				log.Error("key not found in data", "key", v.key)
+				return notFoundError("specified field was not found in data")
+			}
+			continue
+		}
+		exports = append(exports, [2]string{v.name, val})
+	}
+	expiry := credentialExpiry(secret.Data[expirationDataKey], requestedAt, ttl)
+	log.Info("issued credentials", "type", item.Type, logKeyStatus, statusSuccess)
+
+	if item.Profile != "" {
+		if err := profiles.add(item, exports, expiry); err != nil {
+			log.Error("invalid credentials", logKeyError, err)
+			return fmt.Errorf("cannot add aws profile")
+		}
+		return nil
+	}
+	if expiry != "" {
+		exports = append(exports, [2]string{expiryVariables[item.Type], expiry})
+	}
+
+	for _, kv := range exports {
+		name, val := kv[0], kv[1]
+		if item.OutputVariable != "" {
+			name = item.OutputVariable + "_" + name
+		}
+		if err := out.Export(name, val); err != nil {
+			log.Error("unable to export env variable", logKeyPhase, phaseExport, "variable", name, logKeyError, err)
+			return fmt.Errorf("cannot set environment variable")
+		}
+	}
+	return nil
+}
+
+// gcpServiceAccountKey returns the service account key JSON, which DSV may return as an object,
+// a JSON string or a base64 encoded JSON string as the GCP IAM API does.
+func gcpServiceAccountKey(v interface{}) ([]byte, error) {
+	var key []byte
+	switch v := v.(type) {
+	case map[string]interface{}:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("unable to encode service account key: %w", err)
+		}
+		key = b
+	case string:
+		key = []byte(v)
+		if decoded, err := base64.StdEncoding.DecodeString(v); err == nil {
+			key = decoded
+		}
+	default:
+		return nil, fmt.Errorf("key %q not found in data", gcpKeyDataKey)
+	}
+	var parsed struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(key, &parsed); err != nil || parsed.Type == "" {
+		// Never include the content, it holds the private key.
+		return nil, fmt.Errorf("key %q is not a service account key", gcpKeyDataKey)
+	}
+	return key, nil
+}
+
+// credentialExpiry returns when credentials expire in RFC 3339 format, from the expiration
+// returned by DSV as a timestamp or unix time, or else from the requested ttl.
+func credentialExpiry(expiration interface{}, requestedAt time.Time, ttl time.Duration) string {
+	switch v := expiration.(type) {
+	case string:
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return t.UTC().Format(time.RFC3339)
+		}
+	case float64:
+		return time.Unix(int64(v), 0).UTC().Format(time.RFC3339)
+	}
+	if ttl > 0 {
+		return requestedAt.Add(ttl).UTC().Format(time.RFC3339)
+	}
+	return ""
+}
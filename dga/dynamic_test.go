@@ -0,0 +1,113 @@
+package dga_test
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/pterm/pterm"
+
+	dga "github.com/DelineaXPM/dsv-gitlab/dga"
+	"github.com/DelineaXPM/dsv-gitlab/dsv/dsvtest"
+)
+
+// requestedTTLs returns the ttl query of the secret reads server received, by secret path.
+func requestedTTLs(server *dsvtest.Server) map[string]string {
+	ttls := map[string]string{}
+	for _, r := range server.Requests() {
+		if path, ok := strings.CutPrefix(r.Path, "/secrets/"); ok && r.Method == http.MethodGet {
+			ttls[path] = r.Query.Get("ttl")
+		}
+	}
+	return ttls
+}
+
+func TestRunDynamicCredentials(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.SetSecret("aws:deploy", map[string]interface{}{
+		"accessKeyId": "AKIAEXAMPLE", "secretAccessKey": "aws-secret", "sessionToken": "aws-session",
+		"expiration": "2030-01-02T03:04:05+01:00",
+	})
+	server.SetSecret("azure:deploy", map[string]interface{}{"clientId": "azure-client", "clientSecret": "azure-secret", "tenantId": "azure-tenant"})
+	server.SetSecret("gcp:deploy", map[string]interface{}{
+		// Base64 encoded JSON, as the GCP IAM API returns keys.
+		"serviceAccountKey": "eyJ0eXBlIjoic2VydmljZV9hY2NvdW50IiwicHJvamVjdF9pZCI6ImRlbW8ifQ==",
+		"expiration":        float64(1893456000),
+	})
+	opts := hermeticRun(t, server, map[string]string{"DSV_RETRIEVE": `[
+		{"type": "aws", "secretPath": "aws:deploy", "ttl": "15m"},
+		{"type": "azure", "secretPath": "azure:deploy", "ttl": "1h", "outputVariable": "PROD"},
+		{"type": "gcp", "secretPath": "gcp:deploy", "outputDir": "gcp"}
+	]`})
+
+	before := time.Now()
+	is.NoErr(dga.Run(context.Background(), opts))
+
+	is.Equal(requestedTTLs(server), map[string]string{"aws:deploy": "900", "azure:deploy": "3600", "gcp:deploy": ""}) // TTL should be sent in seconds.
+
+	vars := readDotEnv(t, opts)
+	is.Equal(vars["AWS_ACCESS_KEY_ID"], "AKIAEXAMPLE")
+	is.Equal(vars["AWS_SECRET_ACCESS_KEY"], "aws-secret")
+	is.Equal(vars["AWS_SESSION_TOKEN"], "aws-session")
+	is.Equal(vars["AWS_CREDENTIAL_EXPIRATION"], "2030-01-02T02:04:05Z") // Expiry from DSV should be normalized to UTC.
+
+	is.Equal(vars["PROD_AZURE_CLIENT_ID"], "azure-client") // Output variable should prefix the names.
+	is.Equal(vars["PROD_AZURE_CLIENT_SECRET"], "azure-secret")
+	is.Equal(vars["PROD_AZURE_TENANT_ID"], "azure-tenant")
+	_, ok := vars["PROD_AZURE_SUBSCRIPTION_ID"]
+	is.True(!ok) // Optional values missing from the secret should not be exported.
+	expiry, err := time.Parse(time.RFC3339, vars["PROD_AZURE_CREDENTIAL_EXPIRATION"])
+	is.NoErr(err)
+	is.True(!expiry.Before(before.Add(time.Hour).Truncate(time.Second))) // Expiry should fall back to the ttl.
+
+	keyFile := filepath.Join(opts.Root, "gcp", "gcp-credentials.json")
+	is.Equal(vars["GOOGLE_APPLICATION_CREDENTIALS"], keyFile)
+	is.Equal(vars["GOOGLE_CREDENTIAL_EXPIRATION"], "2030-01-01T00:00:00Z")
+	key, err := os.ReadFile(keyFile)
+	is.NoErr(err)
+	is.Equal(string(key), `{"type":"service_account","project_id":"demo"}`)
+}
+
+func TestRunDynamicCredentialsMissingKey(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.SetSecret("aws:deploy", map[string]interface{}{"accessKeyId": "AKIAEXAMPLE"})
+	opts := hermeticRun(t, server, map[string]string{"DSV_RETRIEVE": `[{"type": "aws", "secretPath": "aws:deploy"}]`})
+
+	is.True(dga.Run(context.Background(), opts) != nil) // Should fail without the secret access key.
+	b, err := os.ReadFile(filepath.Join(opts.Root, "dsv_secrets"))
+	is.NoErr(err)
+	is.Equal(string(b), "") // Nothing should be exported.
+}
+
+func TestParseRetrieveDynamic(t *testing.T) {
+	pterm.DisableOutput()
+	cases := []struct {
+		name     string
+		retrieve string
+		wantErr  bool
+	}{
+		{name: "aws", retrieve: `[{"type": "aws", "secretPath": "aws:deploy", "ttl": "7d"}]`},
+		{name: "missing path", retrieve: `[{"type": "azure"}]`, wantErr: true},
+		{name: "gcp without output dir", retrieve: `[{"type": "gcp", "secretPath": "gcp:deploy"}]`, wantErr: true},
+		{name: "invalid ttl", retrieve: `[{"type": "aws", "secretPath": "aws:deploy", "ttl": "soon"}]`, wantErr: true},
+		{name: "negative ttl", retrieve: `[{"type": "aws", "secretPath": "aws:deploy", "ttl": "-5m"}]`, wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			_, err := dga.ParseRetrieve(tc.retrieve)
+			is.Equal(err != nil, tc.wantErr)
+		})
+	}
+}
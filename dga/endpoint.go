@@ -0,0 +1,76 @@
+package dga
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// knownTenantSuffixes are the DSV cloud domains a tenant can live under.
+//
+//nolint:gochecknoglobals // lookup table.
+var knownTenantSuffixes = []string{
+	".secretsvaultcloud.com",
+	".secretsvaultcloud.eu",
+	".secretsvaultcloud.com.au",
+	".secretsvaultcloud.ca",
+	".secretsvaultcloud.com.sg",
+}
+
+// APIEndpoint returns the base URL for DSV API calls.
+// DSV_API_URL is used as is when set, otherwise it is built from DSV_DOMAIN as https://<DSV_DOMAIN>/v1.
+// DSV_DOMAIN must be under a known DSV domain unless DSV_ALLOW_CUSTOM_DOMAIN is set.
+func (cfg *Config) APIEndpoint() (string, error) {
+	if cfg.APIURLEnv != "" {
+		return parseAPIURL(cfg.APIURLEnv)
+	}
+	if err := validateDomain(cfg.DomainEnv, cfg.AllowCustomDomain); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("https://%s/v1", cfg.DomainEnv), nil
+}
+
+// parseAPIURL validates a full API base URL and removes any trailing slash.
+func parseAPIURL(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("DSV_API_URL is not a valid url: %w", err)
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return "", fmt.Errorf("DSV_API_URL %q must start with https:// or http://", raw)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("DSV_API_URL %q is missing a host", raw)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("DSV_API_URL %q must not contain a query or fragment", raw)
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+// validateDomain checks DSV_DOMAIN is a bare tenant host name under a known DSV domain, or any
+// host name when allowCustom is set.
+func validateDomain(domain string, allowCustom bool) error {
+	switch {
+	case domain == "":
+		return errors.New("DSV_DOMAIN is required when DSV_API_URL is not set")
+	case strings.Contains(domain, "://"):
+		return fmt.Errorf("DSV_DOMAIN %q must be a host name without a scheme, e.g. mytenant.secretsvaultcloud.com", domain)
+	case strings.ContainsAny(domain, "/:?# "):
+		return fmt.Errorf("DSV_DOMAIN %q must be a host name without a port or path, use DSV_API_URL for a custom base url", domain)
+	}
+	if allowCustom {
+		return nil
+	}
+	lower := strings.ToLower(domain)
+	for _, suffix := range knownTenantSuffixes {
+		if strings.HasSuffix(lower, suffix) && len(lower) > len(suffix) {
+			return nil
+		}
+		if lower == strings.TrimPrefix(suffix, ".") {
+			return fmt.Errorf("DSV_DOMAIN %q is missing the tenant name, e.g. mytenant%s", domain, suffix)
+		}
+	}
+	return fmt.Errorf("DSV_DOMAIN %q is not under a known DSV domain (%s), set DSV_ALLOW_CUSTOM_DOMAIN for other domains or DSV_API_URL for other endpoints", domain, strings.Join(knownTenantSuffixes, ", "))
+}
@@ -0,0 +1,64 @@
+package dga_test
+
+import (
+	"testing"
+
+	"github.com/matryer/is"
+
+	dga "github.com/DelineaXPM/dsv-gitlab/dga"
+)
+
+func TestAPIEndpoint(t *testing.T) {
+	cases := []struct {
+		name    string
+		cfg     dga.Config
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "tenant domain",
+			cfg:  dga.Config{DomainEnv: "mytenant.secretsvaultcloud.com"},
+			want: "https://mytenant.secretsvaultcloud.com/v1",
+		},
+		{
+			name: "regional tenant domain",
+			cfg:  dga.Config{DomainEnv: "mytenant.secretsvaultcloud.com.au"},
+			want: "https://mytenant.secretsvaultcloud.com.au/v1",
+		},
+		{
+			name: "api url with port and path prefix",
+			cfg:  dga.Config{DomainEnv: "ignored", APIURLEnv: "https://proxy.internal:8443/dsv/v1/"},
+			want: "https://proxy.internal:8443/dsv/v1",
+		},
+		{
+			name: "local mock",
+			cfg:  dga.Config{APIURLEnv: "http://127.0.0.1:8080/v1"},
+			want: "http://127.0.0.1:8080/v1",
+		},
+		{name: "domain with scheme", cfg: dga.Config{DomainEnv: "https://mytenant.secretsvaultcloud.com"}, wantErr: true},
+		{name: "domain with path", cfg: dga.Config{DomainEnv: "mytenant.secretsvaultcloud.com/v1"}, wantErr: true},
+		{name: "domain without tenant", cfg: dga.Config{DomainEnv: "secretsvaultcloud.com"}, wantErr: true},
+		{name: "unknown domain", cfg: dga.Config{DomainEnv: "mytenant.secretsvaultcluod.com"}, wantErr: true},
+		{
+			name: "custom domain allowed",
+			cfg:  dga.Config{DomainEnv: "dsv.example.internal", AllowCustomDomain: true},
+			want: "https://dsv.example.internal/v1",
+		},
+		{name: "custom domain with path", cfg: dga.Config{DomainEnv: "dsv.example.internal/v1", AllowCustomDomain: true}, wantErr: true},
+		{name: "no domain or url", cfg: dga.Config{}, wantErr: true},
+		{name: "api url without scheme", cfg: dga.Config{APIURLEnv: "proxy.internal/v1"}, wantErr: true},
+		{name: "api url with query", cfg: dga.Config{APIURLEnv: "https://proxy.internal/v1?x=1"}, wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			got, err := tc.cfg.APIEndpoint()
+			if tc.wantErr {
+				is.True(err != nil) // Should reject the configuration.
+				return
+			}
+			is.NoErr(err)
+			is.Equal(got, tc.want)
+		})
+	}
+}
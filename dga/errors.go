@@ -0,0 +1,153 @@
+package dga
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/DelineaXPM/dsv-gitlab/dsv"
+)
+
+// ErrorKind classifies why a command failed, so callers such as main can react to the kind of failure.
+type ErrorKind int
+
+const (
+	// ErrorOther is any failure not covered below, such as being unable to write an output file.
+	ErrorOther ErrorKind = iota
+	// ErrorConfig is a missing or invalid setting, such as DSV_RETRIEVE or the client credentials,
+	// including a DSV certificate that is not trusted or does not match DSV_TLS_PINNED_SPKI.
+	ErrorConfig
+	// ErrorAuth is DSV rejecting the client credentials.
+	ErrorAuth
+	// ErrorPermission is DSV denying access to a path, 403 Forbidden.
+	ErrorPermission
+	// ErrorNotFound is a secret, or a key in its data, that does not exist.
+	ErrorNotFound
+	// ErrorNetwork is DSV being unreachable, too slow or unavailable: DNS and connection failures,
+	// timeouts, 408, 429 and 5xx responses.
+	ErrorNetwork
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrorConfig:
+		return "invalid configuration"
+	case ErrorAuth:
+		return "authentication failed"
+	case ErrorPermission:
+		return "permission denied"
+	case ErrorNotFound:
+		return "not found"
+	case ErrorNetwork:
+		return "network error or timeout"
+	default:
+		return "failed"
+	}
+}
+
+// Error is a failure of a known kind. Its message is the message of Err.
+type Error struct {
+	Kind ErrorKind
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// KindOf returns the kind of the first *Error in the chain of err. Exceeded deadlines, such as
+// DSV_TOTAL_TIMEOUT, are ErrorNetwork and everything else is ErrorOther.
+func KindOf(err error) ErrorKind {
+	var e *Error
+	switch {
+	case errors.As(err, &e):
+		return e.Kind
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorNetwork
+	default:
+		return ErrorOther
+	}
+}
+
+// configError marks err as a configuration error, nil when err is nil.
+func configError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Kind: ErrorConfig, Err: err}
+}
+
+// requestError returns an error with msg, classified by cause, the error of a failed DSV request.
+// The cause is logged where it happens and left out of the message.
+func requestError(cause error, msg string) error {
+	return &Error{Kind: requestErrorKind(cause), Err: errors.New(msg)}
+}
+
+// requestErrorKind classifies the error of a DSV request by the response status or network failure.
+// TLS failures are configuration errors, as retrying does not help when DSV is not trusted.
+func requestErrorKind(err error) ErrorKind {
+	var apiErr *dsv.APIError
+	switch {
+	case errors.As(err, &apiErr):
+		switch code := apiErr.StatusCode; {
+		case code == http.StatusUnauthorized:
+			return ErrorAuth
+		case code == http.StatusForbidden:
+			return ErrorPermission
+		case code == http.StatusNotFound:
+			return ErrorNotFound
+		case code == http.StatusRequestTimeout, code == http.StatusTooManyRequests, code >= http.StatusInternalServerError:
+			return ErrorNetwork
+		}
+	case isTLSError(err):
+		return ErrorConfig
+	case errors.Is(err, context.DeadlineExceeded), isNetworkError(err):
+		return ErrorNetwork
+	}
+	return ErrorOther
+}
+
+// isTLSError reports whether err is a failed TLS handshake, such as an untrusted certificate or a pin mismatch.
+func isTLSError(err error) bool {
+	var (
+		verifyErr    *tls.CertificateVerificationError
+		authorityErr x509.UnknownAuthorityError
+		invalidErr   x509.CertificateInvalidError
+		hostnameErr  x509.HostnameError
+		headerErr    tls.RecordHeaderError
+		alertErr     tls.AlertError
+	)
+	return errors.Is(err, errPinMismatch) ||
+		errors.As(err, &verifyErr) || errors.As(err, &authorityErr) || errors.As(err, &invalidErr) ||
+		errors.As(err, &hostnameErr) || errors.As(err, &headerErr) || errors.As(err, &alertErr)
+}
+
+// isNetworkError reports whether err is a timeout, a failed DNS lookup or a failed connection.
+func isNetworkError(err error) bool {
+	var (
+		netErr net.Error
+		dnsErr *net.DNSError
+		opErr  *net.OpError
+	)
+	switch {
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return true
+	case errors.As(err, &dnsErr):
+		return true
+	case errors.As(err, &opErr):
+		return opErr.Op == "dial"
+	}
+	return false
+}
+
+// notFoundError returns an ErrorNotFound with msg.
+func notFoundError(msg string) error {
+	return &Error{Kind: ErrorNotFound, Err: errors.New(msg)}
+}
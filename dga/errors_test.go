@@ -0,0 +1,124 @@
+package dga_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/pterm/pterm"
+
+	dga "github.com/DelineaXPM/dsv-gitlab/dga"
+	"github.com/DelineaXPM/dsv-gitlab/dsv/dsvtest"
+)
+
+func TestRunErrorKinds(t *testing.T) {
+	pterm.DisableOutput()
+	retrieve := `[{"secretPath": "ci:db", "secretKey": "password", "outputVariable": "DB_PASSWORD"}]`
+
+	cases := []struct {
+		name  string
+		env   map[string]string
+		fault *dsvtest.Fault
+		want  dga.ErrorKind
+	}{
+		{name: "missing retrieve", env: map[string]string{}, want: dga.ErrorConfig},
+		{name: "invalid retrieve", env: map[string]string{"DSV_RETRIEVE": `[{"type": "nope"}]`}, want: dga.ErrorConfig},
+		{name: "missing credentials", env: map[string]string{"DSV_RETRIEVE": retrieve, "DSV_CLIENT_SECRET": ""}, want: dga.ErrorConfig},
+		{name: "invalid endpoint", env: map[string]string{"DSV_RETRIEVE": retrieve, "DSV_API_URL": "ftp://dsv"}, want: dga.ErrorConfig},
+		{name: "wrong secret", env: map[string]string{"DSV_RETRIEVE": retrieve, "DSV_CLIENT_SECRET": "wrong"}, want: dga.ErrorAuth},
+		{name: "token forbidden", env: map[string]string{"DSV_RETRIEVE": retrieve}, fault: &dsvtest.Fault{Path: "/token", StatusCode: http.StatusForbidden}, want: dga.ErrorAuth},
+		{name: "token bad request", env: map[string]string{"DSV_RETRIEVE": retrieve}, fault: &dsvtest.Fault{Path: "/token", StatusCode: http.StatusBadRequest}, want: dga.ErrorConfig},
+		{name: "wrong api path", env: map[string]string{"DSV_RETRIEVE": retrieve}, fault: &dsvtest.Fault{Path: "/token", StatusCode: http.StatusNotFound}, want: dga.ErrorConfig},
+		{name: "token outage", env: map[string]string{"DSV_RETRIEVE": retrieve}, fault: &dsvtest.Fault{Path: "/token", StatusCode: http.StatusServiceUnavailable}, want: dga.ErrorNetwork},
+		{name: "forbidden", env: map[string]string{"DSV_RETRIEVE": retrieve}, fault: &dsvtest.Fault{Path: "/secrets/ci:db", StatusCode: http.StatusForbidden}, want: dga.ErrorPermission},
+		{name: "missing secret", env: map[string]string{"DSV_RETRIEVE": `[{"secretPath": "ci:none", "secretKey": "password", "outputVariable": "X"}]`}, want: dga.ErrorNotFound},
+		{name: "missing key", env: map[string]string{"DSV_RETRIEVE": `[{"secretPath": "ci:db", "secretKey": "nope", "outputVariable": "X"}]`}, want: dga.ErrorNotFound},
+		{name: "rate limited", env: map[string]string{"DSV_RETRIEVE": retrieve}, fault: &dsvtest.Fault{Path: "/secrets/ci:db", StatusCode: http.StatusTooManyRequests}, want: dga.ErrorNetwork},
+		{name: "bad request", env: map[string]string{"DSV_RETRIEVE": retrieve}, fault: &dsvtest.Fault{Path: "/secrets/ci:db", StatusCode: http.StatusBadRequest}, want: dga.ErrorOther},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			server := dsvtest.NewServer()
+			defer server.Close()
+			server.SetSecret("ci:db", map[string]interface{}{"password": "s3cret"})
+			if tc.fault != nil {
+				server.Fail(*tc.fault)
+			}
+			err := dga.Run(context.Background(), hermeticRun(t, server, tc.env))
+			is.True(err != nil)
+			is.Equal(dga.KindOf(err), tc.want)
+		})
+	}
+}
+
+func TestRunErrorKindTimeout(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.SetSecret("ci:db", map[string]interface{}{"password": "s3cret"})
+
+	opts := hermeticRun(t, server, map[string]string{
+		"DSV_RETRIEVE":      `[{"secretPath": "ci:db", "secretKey": "password", "outputVariable": "DB_PASSWORD"}]`,
+		"DSV_TOTAL_TIMEOUT": "50ms",
+	})
+	server.SetLatency(time.Second)
+	err := dga.Run(context.Background(), opts)
+	is.Equal(dga.KindOf(err), dga.ErrorNetwork) // Timeouts should be network errors.
+}
+
+func TestRunErrorKindTransport(t *testing.T) {
+	pterm.DisableOutput()
+	retrieve := `[{"secretPath": "ci:db", "secretKey": "password", "outputVariable": "DB_PASSWORD"}]`
+	server := dsvtest.NewServer()
+	defer server.Close()
+	tlsServer := httptest.NewTLSServer(server.Config.Handler)
+	defer tlsServer.Close()
+	caCert := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tlsServer.Certificate().Raw}))
+	closed := dsvtest.NewServer()
+	closed.Close()
+
+	cases := []struct {
+		name string
+		env  map[string]string
+		want dga.ErrorKind
+	}{
+		{name: "untrusted certificate", env: map[string]string{"DSV_API_URL": tlsServer.URL + "/v1"}, want: dga.ErrorConfig},
+		{name: "pin mismatch", env: map[string]string{
+			"DSV_API_URL":         tlsServer.URL + "/v1",
+			"DSV_CA_CERT":         caCert,
+			"DSV_TLS_PINNED_SPKI": base64.StdEncoding.EncodeToString(make([]byte, sha256.Size)),
+		}, want: dga.ErrorConfig},
+		{name: "plain http to tls", env: map[string]string{"DSV_API_URL": strings.Replace(server.URL, "http://", "https://", 1) + "/v1"}, want: dga.ErrorConfig},
+		{name: "connection refused", env: map[string]string{"DSV_API_URL": closed.URL + "/v1"}, want: dga.ErrorNetwork},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			tc.env["DSV_RETRIEVE"] = retrieve
+			opts := hermeticRun(t, server, tc.env)
+			opts.HTTPClient = nil // Build the client from the TLS settings.
+			err := dga.Run(context.Background(), opts)
+			is.True(err != nil)
+			is.Equal(dga.KindOf(err), tc.want)
+		})
+	}
+}
+
+func TestKindOf(t *testing.T) {
+	is := is.New(t)
+	is.Equal(dga.KindOf(errors.New("plain")), dga.ErrorOther)
+	is.Equal(dga.KindOf(context.DeadlineExceeded), dga.ErrorNetwork)
+	wrapped := errors.Join(errors.New("context"), &dga.Error{Kind: dga.ErrorPermission, Err: errors.New("denied")})
+	is.Equal(dga.KindOf(wrapped), dga.ErrorPermission) // Kinds should be found in wrapped errors.
+	is.Equal(dga.ErrorNotFound.String(), "not found")
+}
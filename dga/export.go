@@ -0,0 +1,165 @@
+package dga
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// exporter receives each resolved secret value under its output variable name.
+type exporter interface {
+	Export(key, val string) error
+	// WriteFile writes an output file such as a certificate, creating its directory.
+	WriteFile(path string, data []byte, perm os.FileMode) error
+	// Rollback removes anything exported so far, used when the run does not complete.
+	Rollback() error
+}
+
+// outputFiles writes output files and remembers them so they can be removed on rollback.
+type outputFiles struct {
+	log     *slog.Logger
+	written []string
+}
+
+func (o *outputFiles) WriteFile(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), PermissionReadWriteExecuteOwner); err != nil {
+		return fmt.Errorf("unable to create directory for %s: %w", path, err)
+	}
+	// Remove first so perm applies even when the file already exists.
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("unable to replace %s: %w", path, err)
+	}
+	o.written = append(o.written, path)
+	if err := os.WriteFile(path, data, perm); err != nil {
+		return fmt.Errorf("unable to write %s: %w", path, err)
+	}
+	o.log.Debug("wrote file", logKeyPhase, phaseWrite, "file", path)
+	return nil
+}
+
+// rollback removes the files written so far.
+func (o *outputFiles) rollback() error {
+	var errs []error
+	for _, path := range o.written {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, fmt.Errorf("unable to remove partial output %s: %w", path, err))
+		}
+	}
+	o.written = nil
+	return errors.Join(errs...)
+}
+
+// openExporter returns where resolved variables go: the job dotenv file in GitLab, and in local mode
+// either the --out file or stdout. The returned func closes it.
+func (cfg *Config) openExporter(opts Options) (exporter, func(), error) {
+	var (
+		envFile *os.File
+		err     error
+	)
+	switch {
+	case !cfg.Local:
+		if envFile, err = OpenEnvFile(cfg); err != nil {
+			cfg.log().Error("unable to open env file", logKeyPhase, phaseExport, logKeyError, err)
+			return nil, nil, err
+		}
+	case opts.OutFile != "":
+		if envFile, err = OpenLocalEnvFile(opts.rootPath(opts.OutFile)); err != nil {
+			cfg.log().Error("unable to open local env file", logKeyPhase, phaseExport, logKeyError, err)
+			return nil, nil, err
+		}
+	default:
+		return &printExporter{outputFiles: outputFiles{log: cfg.log()}, w: os.Stdout, showValues: opts.ShowValues}, func() {}, nil
+	}
+
+	out, err := newEnvFileExporter(cfg.log(), envFile)
+	if err != nil {
+		envFile.Close()
+		return nil, nil, err
+	}
+	return out, func() { envFile.Close() }, nil
+}
+
+// envFileExporter writes variables to a dotenv file.
+type envFileExporter struct {
+	outputFiles
+	file   *os.File
+	offset int64 // offset is the file size before the run, restored on rollback.
+}
+
+// newEnvFileExporter returns an exporter appending to file, remembering its current size for rollback.
+func newEnvFileExporter(log *slog.Logger, file *os.File) (*envFileExporter, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("unable to stat %s: %w", file.Name(), err)
+	}
+	return &envFileExporter{outputFiles: outputFiles{log: log}, file: file, offset: info.Size()}, nil
+}
+
+func (e *envFileExporter) Export(key, val string) error {
+	return ExportEnvVariable(e.file, key, val)
+}
+
+func (e *envFileExporter) Rollback() error {
+	err := e.outputFiles.rollback()
+	if truncErr := e.file.Truncate(e.offset); truncErr != nil {
+		err = errors.Join(err, fmt.Errorf("unable to remove partial output from %s: %w", e.file.Name(), truncErr))
+	}
+	return err
+}
+
+// printExporter writes variables to w, masking values unless showValues is set.
+type printExporter struct {
+	outputFiles
+	w          io.Writer
+	showValues bool
+}
+
+func (p *printExporter) Export(key, val string) error {
+	if !p.showValues {
+		val = maskedValue
+	}
+	line, err := encodeDotEnv(strings.ToUpper(key), val)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(p.w, line); err != nil {
+		return fmt.Errorf("could not print variable: %w", err)
+	}
+	return nil
+}
+
+// Rollback removes written files, printed output cannot be taken back.
+func (p *printExporter) Rollback() error {
+	return p.outputFiles.rollback()
+}
+
+// outputPath resolves path relative to CI_PROJECT_DIR unless it is absolute.
+func (cfg *Config) outputPath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(cfg.CIProjectDirectory, path)
+}
+
+// fileTargets collects entries that are assembled into shared files, such as the AWS profiles,
+// which are written once every entry has been processed.
+type fileTargets struct {
+	aws    awsProfiles
+	docker dockerAuths
+	kube   kubeconfig
+}
+
+// write writes the files of every target that has entries.
+func (t *fileTargets) write(cfg *Config, out exporter) error {
+	if err := t.aws.write(cfg.log(), out, cfg.outputPath(cfg.AWSProfileDir)); err != nil {
+		return err
+	}
+	if err := t.docker.write(cfg.log(), out, cfg.outputPath(cfg.DockerConfigDir)); err != nil {
+		return err
+	}
+	return t.kube.write(cfg.log(), out, cfg.outputPath(cfg.KubeconfigDir))
+}
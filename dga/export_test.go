@@ -0,0 +1,4 @@
+package dga
+
+// NewFileTokenCache exposes newFileTokenCache to the external tests.
+var NewFileTokenCache = newFileTokenCache //nolint:gochecknoglobals // test hook.
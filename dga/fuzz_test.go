@@ -0,0 +1,214 @@
+package dga_test
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/pterm/pterm"
+
+	dga "github.com/DelineaXPM/dsv-gitlab/dga"
+)
+
+// gitlabDotEnvLine is the line format of GitLab's dotenv report parser, split at the first =.
+var gitlabDotEnvLine = regexp.MustCompile(`^(.*?)=(.*)$`)
+
+// gitlabDotEnvKey is the variable name format GitLab accepts.
+var gitlabDotEnvKey = regexp.MustCompile(`\A[a-zA-Z0-9_]+\z`)
+
+// parseGitLabDotEnv parses a dotenv report as GitLab does (Ci::ParseDotenvArtifactService): each line
+// is split at the first =, key and value are stripped of surrounding whitespace and NULs as Ruby's
+// String#strip does, and one pair of matching surrounding quotes is removed from the value.
+func parseGitLabDotEnv(content string) ([][2]string, error) {
+	var vars [][2]string
+	for _, line := range strings.SplitAfter(content, "\n") {
+		if line == "" {
+			continue
+		}
+		m := gitlabDotEnvLine.FindStringSubmatch(strings.TrimSuffix(line, "\n"))
+		if m == nil {
+			return nil, errors.New("invalid format")
+		}
+		key, val := strings.Trim(m[1], " \t\n\v\f\r\x00"), strings.Trim(m[2], " \t\n\v\f\r\x00")
+		if !gitlabDotEnvKey.MatchString(key) {
+			return nil, errors.New("invalid key")
+		}
+		if len(val) > 0 && (val[0] == '"' && val[len(val)-1] == '"' || val[0] == '\'' && val[len(val)-1] == '\'') {
+			// Ruby's value[1..-2], which is empty for a lone quote.
+			if len(val) == 1 {
+				val = ""
+			} else {
+				val = val[1 : len(val)-1]
+			}
+		}
+		vars = append(vars, [2]string{key, val})
+	}
+	return vars, nil
+}
+
+func TestExportEnvVariableRoundTrip(t *testing.T) {
+	pterm.DisableOutput()
+	values := []string{
+		"", "plain", "with space", " leading", "trailing ", "\ttab", `"quoted"`, `'single'`, `"`, `'`, `""`,
+		`"mixed'`, `a"b"c`, "a=b=c", "#not a comment", "export X=1", "$HOME ${PATH}", "trailing\r", "nul\x00", "\x00",
+		"ünïcödé ✓", `\n literal`, `p@ss word="x"`,
+	}
+	for _, val := range values {
+		is := is.New(t)
+		got := roundTrip(t, "KEY", val)
+		is.Equal(got, [][2]string{{"KEY", val}}) // Value should read back unchanged.
+	}
+}
+
+func TestExportEnvVariableRejects(t *testing.T) {
+	pterm.DisableOutput()
+	cases := []struct {
+		name string
+		key  string
+		val  string
+	}{
+		{name: "newline", key: "KEY", val: "line1\nline2"},
+		{name: "trailing newline", key: "KEY", val: "value\n"},
+		{name: "empty key", key: "", val: "value"},
+		{name: "dash in key", key: "MY-KEY", val: "value"},
+		{name: "equals in key", key: "A=B", val: "value"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			f := tempDotEnv(t)
+			is.True(dga.ExportEnvVariable(f, tc.key, tc.val) != nil) // Should be rejected.
+			info, err := f.Stat()
+			is.NoErr(err)
+			is.Equal(info.Size(), int64(0)) // Nothing should be written.
+		})
+	}
+	is := is.New(t)
+	is.True(errors.Is(dga.ExportEnvVariable(tempDotEnv(t), "KEY", "a\nb"), dga.ErrUnencodableValue))
+}
+
+func FuzzExportEnvVariable(f *testing.F) {
+	pterm.DisableOutput()
+	for _, seed := range []string{"", "value", " x ", `"x"`, `'`, "a\nb", "a=b", "\x00", "\r"} {
+		f.Add("KEY", seed)
+	}
+	f.Add("lower_case", "v")
+	f.Add("BAD-KEY", "v")
+	f.Fuzz(func(t *testing.T, key, val string) {
+		file := tempDotEnv(t)
+		if err := dga.ExportEnvVariable(file, key, val); err != nil {
+			info, statErr := file.Stat()
+			if statErr != nil {
+				t.Fatal(statErr)
+			}
+			if info.Size() != 0 {
+				t.Fatalf("rejected %q=%q but wrote %d bytes", key, val, info.Size())
+			}
+			if !strings.Contains(val, "\n") && gitlabDotEnvKey.MatchString(key) {
+				t.Fatalf("rejected encodable %q=%q: %v", key, val, err)
+			}
+			return
+		}
+		b, err := os.ReadFile(file.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := parseGitLabDotEnv(string(b))
+		if err != nil {
+			t.Fatalf("GitLab cannot parse %q: %v", b, err)
+		}
+		want := [][2]string{{strings.ToUpper(key), val}}
+		if len(got) != 1 || got[0] != want[0] {
+			t.Fatalf("wrote %q, GitLab reads %q, want %q", b, got, want)
+		}
+	})
+}
+
+func FuzzParseRetrieve(f *testing.F) {
+	pterm.DisableOutput()
+	for _, seed := range []string{
+		``,
+		`[]`,
+		`null`,
+		`[{"secretPath": "ci:db", "secretKey": "password", "outputVariable": "DB_PASSWORD"}]`,
+		`[{"type": "pki", "rootCAPath": "root", "commonName": "app.internal", "outputDir": "certs"}]`,
+		`[{"type": "ssh", "rootCAPath": "ssh", "principals": ["deploy"], "outputDir": ".ssh"}]`,
+		`[{"type": "aws", "secretPath": "aws:dev", "profile": "dev", "ttl": "7d"}]`,
+		`[{"type": "gcp", "secretPath": "gcp:dev", "outputDir": "gcp"}]`,
+		`[{"type": "docker", "secretPath": "ci:registry", "registry": "docker.io"}]`,
+		`[{"type": "kube", "secretPath": "ci:kube", "context": "prod", "currentContext": true}]`,
+		`[{"type": "unknown"}]`,
+		`[{"arg1": "path"},]`,
+	} {
+		f.Add(seed)
+	}
+	known := map[string]bool{
+		"": true, dga.EntryTypeSecret: true, dga.EntryTypePKI: true, dga.EntryTypeSSH: true, dga.EntryTypeAWS: true,
+		dga.EntryTypeAzure: true, dga.EntryTypeGCP: true, dga.EntryTypeDocker: true, dga.EntryTypeKube: true,
+	}
+	f.Fuzz(func(t *testing.T, retrieve string) {
+		entries, err := dga.ParseRetrieve(retrieve)
+		if err != nil {
+			if len(entries) != 0 {
+				t.Fatalf("error %v with %d entries", err, len(entries))
+			}
+			return
+		}
+		for i, e := range entries {
+			if !known[e.Type] {
+				t.Fatalf("entry %d: accepted unknown type %q", i, e.Type)
+			}
+			// Accepted names can be exported, so a run never fails on them after reading secrets.
+			if (e.OutputVariable != "" || e.Type == "" || e.Type == dga.EntryTypeSecret) && !gitlabDotEnvKey.MatchString(e.OutputVariable) {
+				t.Fatalf("entry %d: accepted output variable %q that cannot be exported", i, e.OutputVariable)
+			}
+		}
+		// Valid entries stay valid and unchanged when encoded and parsed again.
+		b, err := json.Marshal(entries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, err := dga.ParseRetrieve(string(b))
+		if err != nil {
+			t.Fatalf("re-parsing %s: %v", b, err)
+		}
+		a1, _ := json.Marshal(again)
+		if string(a1) != string(b) {
+			t.Fatalf("re-parsing changed entries:\n%s\n%s", b, a1)
+		}
+	})
+}
+
+// roundTrip exports key and val to a new dotenv file and parses it as GitLab does.
+func roundTrip(t *testing.T, key, val string) [][2]string {
+	t.Helper()
+	file := tempDotEnv(t)
+	if err := dga.ExportEnvVariable(file, key, val); err != nil {
+		t.Fatalf("export %q: %v", val, err)
+	}
+	b, err := os.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars, err := parseGitLabDotEnv(string(b))
+	if err != nil {
+		t.Fatalf("parse %q: %v", b, err)
+	}
+	return vars
+}
+
+// tempDotEnv returns a new empty dotenv file, closed when the test ends.
+func tempDotEnv(t *testing.T) *os.File {
+	t.Helper()
+	file, err := os.Create(filepath.Join(t.TempDir(), "job"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+	return file
+}
@@ -0,0 +1,183 @@
+package dga
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Git credential helper operations, passed by git as the last argument.
+const (
+	GitCredentialGet   = "get"
+	GitCredentialStore = "store"
+	GitCredentialErase = "erase"
+)
+
+// GitCredentialMapping maps repositories on a host to the DSV secret holding their credentials.
+//
+//nolint:tagliatelle // Here 'camel' casing is used to match DSV_RETRIEVE.
+type GitCredentialMapping struct {
+	Host        string `yaml:"host"`        // Host is a glob matched against the host and port, such as gitlab.com or *.example.com.
+	Path        string `yaml:"path"`        // Path is a glob matched against the leading segments of the repository path, any path when empty.
+	SecretPath  string `yaml:"secretPath"`  // SecretPath is the secret holding the credentials.
+	Username    string `yaml:"username"`    // Username is used when the secret has no user name, such as oauth2 for GitLab tokens.
+	UsernameKey string `yaml:"usernameKey"` // UsernameKey is the data key holding the user name, username by default.
+	PasswordKey string `yaml:"passwordKey"` // PasswordKey is the data key holding the password or token, password by default.
+}
+
+// ParseGitCredentials parses a JSON or YAML list of git credential mappings.
+func ParseGitCredentials(spec string) ([]GitCredentialMapping, error) {
+	mappings := []GitCredentialMapping{}
+	if err := yaml.Unmarshal([]byte(spec), &mappings); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal git credential mappings: %w", err)
+	}
+	for i := range mappings {
+		if err := mappings[i].validate(); err != nil {
+			return nil, fmt.Errorf("mapping %d: %w", i, err)
+		}
+	}
+	return mappings, nil
+}
+
+// validate checks the mapping has a host and secret path and that its patterns are valid.
+func (m *GitCredentialMapping) validate() error {
+	if m.Host == "" || m.SecretPath == "" {
+		return errors.New("git credential mappings require host and secretPath")
+	}
+	for _, pattern := range append([]string{m.Host}, strings.Split(m.Path, "/")...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// matches reports whether the mapping applies to the repository at repoPath on host. A path
+// pattern only matches when git sends the path, which needs credential.useHttpPath.
+func (m *GitCredentialMapping) matches(host, repoPath string) bool {
+	if ok, _ := path.Match(strings.ToLower(m.Host), strings.ToLower(host)); !ok {
+		return false
+	}
+	pattern := strings.Trim(m.Path, "/")
+	if pattern == "" {
+		return true
+	}
+	segments := strings.Split(strings.Trim(repoPath, "/"), "/")
+	for i, p := range strings.Split(pattern, "/") {
+		if i >= len(segments) {
+			return false
+		}
+		if ok, _ := path.Match(p, segments[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// readGitCredentialRequest reads the key=value lines git sends up to a blank line or the end of input.
+func readGitCredentialRequest(in io.Reader) (map[string]string, error) {
+	request := map[string]string{}
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+		if k, v, ok := strings.Cut(line, "="); ok {
+			request[k] = v
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read git credential request: %w", err)
+	}
+	return request, nil
+}
+
+// GitCredential implements the git credential helper protocol for operation, reading the request
+// from in and writing the credentials of the first mapping in DSV_GIT_CREDENTIALS that matches to
+// out. Nothing is written when no mapping matches, so git falls back to its other helpers.
+// The helper is read-only, store and erase are accepted and ignored.
+func GitCredential(ctx context.Context, opts Options, operation string, in io.Reader, out io.Writer) error {
+	request, err := readGitCredentialRequest(in)
+	if err != nil {
+		return err
+	}
+	switch operation {
+	case GitCredentialGet:
+	case GitCredentialStore, GitCredentialErase:
+		return nil
+	default:
+		return configError(fmt.Errorf("unsupported git credential operation %q, expected %s, %s or %s",
+			operation, GitCredentialGet, GitCredentialStore, GitCredentialErase))
+	}
+
+	opts.helper = true
+	cfg, err := parseConfig(opts)
+	if err != nil {
+		return configError(err)
+	}
+	cfg.logDebug()
+	if cfg.GitCredentialsEnv == "" {
+		return configError(errors.New("DSV_GIT_CREDENTIALS is required"))
+	}
+	mappings, err := ParseGitCredentials(cfg.GitCredentialsEnv)
+	if err != nil {
+		return configError(err)
+	}
+
+	host, repoPath := request["host"], request["path"]
+	var mapping *GitCredentialMapping
+	for i := range mappings {
+		if mappings[i].matches(host, repoPath) {
+			mapping = &mappings[i]
+			break
+		}
+	}
+	if mapping == nil {
+		cfg.log().Debug("no git credential mapping", logKeyPhase, phaseHelper, "host", host, "repository", repoPath)
+		return nil
+	}
+
+	ctx, cancel := cfg.withTimeout(ctx)
+	defer cancel()
+	client, err := cfg.connect(ctx)
+	if err != nil {
+		return err
+	}
+	log := cfg.log().With(logKeyPhase, phaseHelper, logKeyPath, mapping.SecretPath)
+	secret, err := client.GetSecret(ctx, mapping.SecretPath)
+	if err != nil {
+		log.Error("failed to fetch secret", logKeyError, err)
+		return requestError(err, "unable to get secret")
+	}
+
+	usernameKey, passwordKey := stringOr(mapping.UsernameKey, defaultUsernameKey), stringOr(mapping.PasswordKey, defaultPasswordKey)
+	username, _ := secret.Data[usernameKey].(string)
+	username = stringOr(username, mapping.Username)
+	password, _ := secret.Data[passwordKey].(string)
+	if password == "" {
+		log.Error("key not found in data", "key", passwordKey)
+		return notFoundError("specified field was not found in data")
+	}
+	if strings.ContainsAny(username+password, "\n\x00") {
+		log.Error("credentials contain a newline or NUL, which git cannot read")
+		return fmt.Errorf("invalid git credentials")
+	}
+
+	var b strings.Builder
+	if username != "" {
+		fmt.Fprintf(&b, "username=%s\n", username)
+	}
+	fmt.Fprintf(&b, "password=%s\n", password)
+	if _, err := io.WriteString(out, b.String()); err != nil {
+		return fmt.Errorf("unable to write git credentials: %w", err)
+	}
+	log.Info("provided git credentials", "host", host, logKeyStatus, statusSuccess)
+	return nil
+}
@@ -0,0 +1,130 @@
+package dga_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/pterm/pterm"
+
+	dga "github.com/DelineaXPM/dsv-gitlab/dga"
+	"github.com/DelineaXPM/dsv-gitlab/dsv/dsvtest"
+)
+
+func TestGitCredential(t *testing.T) {
+	pterm.DisableOutput()
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.SetSecret("ci:git:platform", map[string]interface{}{"password": "glpat-platform"})
+	server.SetSecret("ci:git:gitlab", map[string]interface{}{"username": "deploy", "password": "glpat-any"})
+	server.SetSecret("ci:git:github", map[string]interface{}{"user": "bot", "token": "ghp"})
+	opts := hermeticRun(t, server, map[string]string{"DSV_GIT_CREDENTIALS": `
+- host: gitlab.com
+  path: platform/*
+  secretPath: ci:git:platform
+  username: oauth2
+- host: gitlab.com
+  secretPath: ci:git:gitlab
+- host: "*.github.com"
+  secretPath: ci:git:github
+  usernameKey: user
+  passwordKey: token
+`})
+
+	cases := []struct {
+		name      string
+		operation string
+		request   string
+		want      string
+	}{
+		{name: "path match", operation: "get", request: "protocol=https\nhost=gitlab.com\npath=platform/libs/tools.git\n\n", want: "username=oauth2\npassword=glpat-platform\n"},
+		{name: "host match", operation: "get", request: "protocol=https\nhost=gitlab.com\npath=other/repo.git\n", want: "username=deploy\npassword=glpat-any\n"},
+		{name: "no path sent", operation: "get", request: "protocol=https\nhost=GitLab.com\n", want: "username=deploy\npassword=glpat-any\n"},
+		{name: "host pattern", operation: "get", request: "protocol=https\nhost=api.github.com\n", want: "username=bot\npassword=ghp\n"},
+		{name: "no match", operation: "get", request: "protocol=https\nhost=bitbucket.org\n", want: ""},
+		{name: "store ignored", operation: "store", request: "protocol=https\nhost=gitlab.com\nusername=u\npassword=p\n", want: ""},
+		{name: "erase ignored", operation: "erase", request: "protocol=https\nhost=gitlab.com\n", want: ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			out := &bytes.Buffer{}
+			is.NoErr(dga.GitCredential(context.Background(), opts, tc.operation, strings.NewReader(tc.request), out))
+			is.Equal(out.String(), tc.want)
+		})
+	}
+}
+
+func TestGitCredentialErrors(t *testing.T) {
+	pterm.DisableOutput()
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.SetSecret("ci:git:empty", map[string]interface{}{"username": "deploy"})
+	cases := []struct {
+		name      string
+		operation string
+		mappings  string
+	}{
+		{name: "unknown operation", operation: "list", mappings: `[{"host": "gitlab.com", "secretPath": "ci:git:empty"}]`},
+		{name: "missing password", operation: "get", mappings: `[{"host": "gitlab.com", "secretPath": "ci:git:empty"}]`},
+		{name: "missing secret", operation: "get", mappings: `[{"host": "gitlab.com", "secretPath": "ci:git:missing"}]`},
+		{name: "no mappings", operation: "get", mappings: ``},
+		{name: "bad pattern", operation: "get", mappings: `[{"host": "gitlab.com", "path": "[", "secretPath": "ci:git:empty"}]`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			opts := hermeticRun(t, server, map[string]string{"DSV_GIT_CREDENTIALS": tc.mappings})
+			out := &bytes.Buffer{}
+			err := dga.GitCredential(context.Background(), opts, tc.operation, strings.NewReader("host=gitlab.com\n"), out)
+			is.True(err != nil)        // Should fail.
+			is.Equal(out.String(), "") // Nothing should be written for git to read.
+		})
+	}
+}
+
+func TestGitCredentialLocalSources(t *testing.T) {
+	pterm.DisableOutput()
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.SetSecret("ci:git:gitlab", map[string]interface{}{"username": "deploy", "password": "glpat-any"})
+	other := dsvtest.NewServer()
+	defer other.Close()
+
+	cases := []struct {
+		name    string
+		dotenv  string
+		envFile string
+		want    string
+		wantErr bool
+	}{
+		{name: "default env file ignored", dotenv: "DSV_API_URL=" + other.BaseURL() + "\n", wantErr: true},
+		{name: "explicit env file", dotenv: "DSV_API_URL=" + server.BaseURL() + "\n", envFile: ".env", want: "username=deploy\npassword=glpat-any\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			opts := hermeticRun(t, server, map[string]string{
+				"DSV_API_URL":         "",
+				"DSV_GIT_CREDENTIALS": `[{"host": "gitlab.com", "secretPath": "ci:git:gitlab"}]`,
+			})
+			opts.Local = true
+			opts.EnvFile = tc.envFile
+			is.NoErr(os.WriteFile(filepath.Join(opts.Root, ".env"), []byte(tc.dotenv), 0o600))
+
+			out := &bytes.Buffer{}
+			err := dga.GitCredential(context.Background(), opts, dga.GitCredentialGet, strings.NewReader("protocol=https\nhost=gitlab.com\n"), out)
+			is.Equal(len(other.Requests()), 0) // The client secret should never be sent to the .env server.
+			if tc.wantErr {
+				is.Equal(dga.KindOf(err), dga.ErrorConfig) // Without DSV_API_URL or DSV_DOMAIN there is no endpoint.
+				return
+			}
+			is.NoErr(err)
+			is.Equal(out.String(), tc.want)
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package dga
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// junitSuiteName names the report test suite when CI_JOB_NAME is not set.
+const junitSuiteName = "dsv-gitlab"
+
+// junitConfigTestCase names the test case reporting a run that stopped before DSV_RETRIEVE was parsed.
+const junitConfigTestCase = "configuration"
+
+// junitTestSuites is the root element of a JUnit XML report.
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+}
+
+// writeJUnitReport writes the results of a run to DSV_JUNIT_REPORT, when set, with one test case per
+// DSV_RETRIEVE entry. runErr is why the run stopped, given as the reason entries were skipped, or as
+// a single failing test case when the run stopped before DSV_RETRIEVE was parsed.
+// The report only holds paths, keys, variable names and error messages, never values.
+func (cfg *Config) writeJUnitReport(results []entryResult, total time.Duration, runErr error) error {
+	if cfg.JUnitReport == "" {
+		return nil
+	}
+	suite := junitTestSuite{Name: cfg.CIJobName, Tests: len(results), Time: junitSeconds(total)}
+	if suite.Name == "" {
+		suite.Name = junitSuiteName
+	}
+	for _, r := range results {
+		tc := junitTestCase{Name: r.Path, ClassName: r.Type, Time: junitSeconds(r.Duration)}
+		if r.Key != "" {
+			tc.Name += " " + r.Key
+		}
+		if r.Variable != "" {
+			tc.Name += " -> " + r.Variable
+		}
+		switch r.Status {
+		case resultFailed:
+			suite.Failures++
+			tc.Failure = &junitMessage{Message: failureReason(r.Err), Type: KindOf(r.Err).String()}
+		case resultSkipped:
+			suite.Skipped++
+			tc.Skipped = &junitMessage{Message: "not run"}
+			if runErr != nil {
+				tc.Skipped.Message += ": " + failureReason(runErr)
+			}
+		}
+		suite.TestCases = append(suite.TestCases, tc)
+	}
+	if len(results) == 0 && runErr != nil {
+		suite.Tests, suite.Failures = 1, 1
+		suite.TestCases = []junitTestCase{{
+			Name: junitConfigTestCase, ClassName: phaseConfig, Time: suite.Time,
+			Failure: &junitMessage{Message: failureReason(runErr), Type: KindOf(runErr).String()},
+		}}
+	}
+	report := junitTestSuites{
+		Name: junitSuiteName, Tests: suite.Tests, Failures: suite.Failures, Skipped: suite.Skipped, Time: suite.Time,
+		Suites: []junitTestSuite{suite},
+	}
+
+	b, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to encode junit report: %w", err)
+	}
+	path := cfg.outputPath(cfg.JUnitReport)
+	if err := os.MkdirAll(filepath.Dir(path), PermissionReadWriteExecuteOwner); err != nil {
+		return fmt.Errorf("unable to create directory for %s: %w", path, err)
+	}
+	if err := os.WriteFile(path, append([]byte(xml.Header), append(b, '\n')...), PermissionReadWriteOwner); err != nil {
+		return fmt.Errorf("unable to write junit report: %w", err)
+	}
+	cfg.log().Debug("wrote junit report", logKeyPhase, phaseWrite, "file", path, "tests", suite.Tests, "failures", suite.Failures)
+	return nil
+}
+
+// failureReason returns the message of err followed by its kind, when known.
+func failureReason(err error) string {
+	if err == nil {
+		return ""
+	}
+	if kind := KindOf(err); kind != ErrorOther {
+		return fmt.Sprintf("%v (%s)", err, kind)
+	}
+	return err.Error()
+}
+
+// junitSeconds formats d as JUnit test times are, in seconds.
+func junitSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
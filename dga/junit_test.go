@@ -0,0 +1,140 @@
+package dga_test
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/pterm/pterm"
+
+	dga "github.com/DelineaXPM/dsv-gitlab/dga"
+	"github.com/DelineaXPM/dsv-gitlab/dsv/dsvtest"
+)
+
+// junitReport is the part of a JUnit XML report the tests check.
+type junitReport struct {
+	Tests    int `xml:"tests,attr"`
+	Failures int `xml:"failures,attr"`
+	Skipped  int `xml:"skipped,attr"`
+	Suites   []struct {
+		Name      string `xml:"name,attr"`
+		TestCases []struct {
+			Name      string `xml:"name,attr"`
+			ClassName string `xml:"classname,attr"`
+			Failure   *struct {
+				Message string `xml:"message,attr"`
+				Type    string `xml:"type,attr"`
+			} `xml:"failure"`
+			Skipped *struct {
+				Message string `xml:"message,attr"`
+			} `xml:"skipped"`
+		} `xml:"testcase"`
+	} `xml:"testsuite"`
+}
+
+func TestRunJUnitReport(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.SetSecret("ci:db", map[string]interface{}{"password": "s3cret-value"})
+	server.SetSecret("ci:cache", map[string]interface{}{"token": "t0ken-value"})
+	server.Fail(dsvtest.Fault{Path: "/secrets/ci:cache", StatusCode: http.StatusForbidden})
+
+	opts := hermeticRun(t, server, map[string]string{"DSV_JUNIT_REPORT": "reports/dsv.xml", "DSV_RETRIEVE": `[
+		{"secretPath": "ci:db", "secretKey": "password", "outputVariable": "DB_PASSWORD"},
+		{"secretPath": "ci:cache", "secretKey": "token", "outputVariable": "CACHE_TOKEN"},
+		{"type": "docker", "secretPath": "ci:registry", "registry": "registry.example.com"}
+	]`})
+	is.True(dga.Run(context.Background(), opts) != nil) // The forbidden secret should fail the run.
+
+	b, err := os.ReadFile(filepath.Join(opts.Root, "reports", "dsv.xml"))
+	is.NoErr(err)
+	is.True(strings.HasPrefix(string(b), xml.Header))
+	is.True(!strings.Contains(string(b), "value")) // Values should never be in the report.
+	report := junitReport{}
+	is.NoErr(xml.Unmarshal(b, &report))
+	is.Equal(report.Tests, 3)
+	is.Equal(report.Failures, 1)
+	is.Equal(report.Skipped, 1)
+	is.Equal(len(report.Suites), 1)
+	suite := report.Suites[0]
+	is.Equal(suite.Name, "dsv_secrets") // The suite should be named after the job.
+	is.Equal(len(suite.TestCases), 3)
+
+	passed, failed, skipped := suite.TestCases[0], suite.TestCases[1], suite.TestCases[2]
+	is.Equal(passed.Name, "ci:db password -> DB_PASSWORD")
+	is.Equal(passed.ClassName, "secret")
+	is.True(passed.Failure == nil && passed.Skipped == nil)
+	is.Equal(failed.Name, "ci:cache token -> CACHE_TOKEN")
+	is.Equal(failed.Failure.Message, "unable to get secret (permission denied)")
+	is.Equal(failed.Failure.Type, "permission denied")
+	is.Equal(skipped.Name, "ci:registry registry.example.com")
+	is.Equal(skipped.ClassName, "docker")
+	is.Equal(skipped.Skipped.Message, "not run: unable to get secret (permission denied)")
+}
+
+func TestRunJUnitReportAuthFailure(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+	server := dsvtest.NewServer()
+	defer server.Close()
+
+	opts := hermeticRun(t, server, map[string]string{
+		"DSV_JUNIT_REPORT":  "dsv.xml",
+		"DSV_CLIENT_SECRET": "wrong",
+		"DSV_RETRIEVE":      `[{"secretPath": "ci:db", "secretKey": "password", "outputVariable": "DB_PASSWORD"}]`,
+	})
+	is.True(dga.Run(context.Background(), opts) != nil)
+
+	b, err := os.ReadFile(filepath.Join(opts.Root, "dsv.xml"))
+	is.NoErr(err)
+	report := junitReport{}
+	is.NoErr(xml.Unmarshal(b, &report))
+	is.Equal(report.Skipped, 1)
+	is.Equal(report.Suites[0].TestCases[0].Skipped.Message, "not run: unable to get access token (authentication failed)")
+}
+
+func TestRunJUnitReportConfigFailure(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+	server := dsvtest.NewServer()
+	defer server.Close()
+
+	opts := hermeticRun(t, server, map[string]string{"DSV_JUNIT_REPORT": "dsv.xml", "DSV_RETRIEVE": `[{"secretPath": "ci:db",`})
+	err := dga.Run(context.Background(), opts)
+	is.Equal(dga.KindOf(err), dga.ErrorConfig)
+
+	b, err := os.ReadFile(filepath.Join(opts.Root, "dsv.xml"))
+	is.NoErr(err) // The report should be written even though DSV_RETRIEVE could not be parsed.
+	report := junitReport{}
+	is.NoErr(xml.Unmarshal(b, &report))
+	is.Equal(report.Tests, 1)
+	is.Equal(report.Failures, 1)
+	is.Equal(len(report.Suites[0].TestCases), 1)
+	tc := report.Suites[0].TestCases[0]
+	is.Equal(tc.Name, "configuration")
+	is.Equal(tc.ClassName, "config")
+	is.Equal(tc.Failure.Message, "unable to unmarshal: unexpected end of JSON input (invalid configuration)") // The failure should carry the parse error.
+	is.Equal(tc.Failure.Type, "invalid configuration")
+	is.Equal(len(server.Requests()), 0) // Nothing should be read from DSV.
+}
+
+func TestRunJUnitReportUnset(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.SetSecret("ci:db", map[string]interface{}{"password": "s3cret"})
+
+	opts := hermeticRun(t, server, map[string]string{"DSV_RETRIEVE": `[{"secretPath": "ci:db", "secretKey": "password", "outputVariable": "DB_PASSWORD"}]`})
+	is.NoErr(dga.Run(context.Background(), opts))
+	entries, err := os.ReadDir(opts.Root)
+	is.NoErr(err)
+	is.Equal(len(entries), 1) // Only the dotenv file should be written.
+}
@@ -0,0 +1,254 @@
+package dga
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/DelineaXPM/dsv-gitlab/dsv"
+	yaml "gopkg.in/yaml.v3"
+)
+
+const (
+	// EntryTypeKube adds a cluster from a secret to a kubeconfig.
+	EntryTypeKube = "kube"
+
+	kubeconfigFileName = "config"
+)
+
+// Data keys of kube entry secrets.
+const (
+	kubeServerKey     = "server"
+	kubeCAKey         = "certificateAuthorityData"
+	kubeTokenKey      = "token"
+	kubeClientCertKey = "clientCertificateData"
+	kubeClientKeyKey  = "clientKeyData"
+	kubeNamespaceKey  = "namespace"
+)
+
+// kubeconfig is the subset of the kubectl config file format written here.
+//
+//nolint:tagliatelle // Here kubeconfig field names are used.
+type kubeconfig struct {
+	APIVersion     string             `yaml:"apiVersion"`
+	Kind           string             `yaml:"kind"`
+	Clusters       []kubeNamedCluster `yaml:"clusters"`
+	Contexts       []kubeNamedContext `yaml:"contexts"`
+	Users          []kubeNamedUser    `yaml:"users"`
+	CurrentContext string             `yaml:"current-context"`
+}
+
+type kubeNamedCluster struct {
+	Name    string      `yaml:"name"`
+	Cluster kubeCluster `yaml:"cluster"`
+}
+
+//nolint:tagliatelle // Here kubeconfig field names are used.
+type kubeCluster struct {
+	Server                   string `yaml:"server"`
+	CertificateAuthorityData string `yaml:"certificate-authority-data,omitempty"`
+}
+
+type kubeNamedContext struct {
+	Name    string      `yaml:"name"`
+	Context kubeContext `yaml:"context"`
+}
+
+type kubeContext struct {
+	Cluster   string `yaml:"cluster"`
+	User      string `yaml:"user"`
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+type kubeNamedUser struct {
+	Name string   `yaml:"name"`
+	User kubeUser `yaml:"user"`
+}
+
+//nolint:tagliatelle // Here kubeconfig field names are used.
+type kubeUser struct {
+	Token                 string `yaml:"token,omitempty"`
+	ClientCertificateData string `yaml:"client-certificate-data,omitempty"`
+	ClientKeyData         string `yaml:"client-key-data,omitempty"`
+}
+
+// validateKube checks the fields of a kube entry.
+func (s *SecretToRetrieve) validateKube() error {
+	if s.SecretPath == "" || s.Context == "" {
+		return errors.New("kube entries require secretPath and context")
+	}
+	return nil
+}
+
+// addKubeContext reads a cluster from the secret at item.SecretPath and adds it to config as a
+// cluster, user and context all named item.Context. The first context added, or the one marked
+// current, becomes the current context.
+func (cfg *Config) addKubeContext(ctx context.Context, client *dsv.Client, config *kubeconfig, item SecretToRetrieve) error {
+	log := cfg.log().With(logKeyPhase, phaseRetrieve, logKeyPath, item.SecretPath)
+	log.Debug("start processing", "context", item.Context)
+	for _, existing := range config.Contexts {
+		if existing.Name == item.Context {
+			log.Error("context is used by more than one entry", "context", item.Context)
+			return fmt.Errorf("duplicate kube context")
+		}
+	}
+	secret, err := client.GetSecret(ctx, item.SecretPath)
+	if err != nil {
+		log.Error("failed to fetch secret", logKeyError, err)
+		return requestError(err, "unable to get secret")
+	}
+	str := func(key string) string {
+		s, _ := secret.Data[key].(string)
+		return s
+	}
+
+	cluster := kubeCluster{Server: str(kubeServerKey), CertificateAuthorityData: kubeconfigData(str(kubeCAKey))}
+	user := kubeUser{
+		Token:                 str(kubeTokenKey),
+		ClientCertificateData: kubeconfigData(str(kubeClientCertKey)),
+		ClientKeyData:         kubeconfigData(str(kubeClientKeyKey)),
+	}
+	switch {
+	case cluster.Server == "":
+		log.Error("key not found in data", "key", kubeServerKey)
+		return notFoundError("specified field was not found in data")
+	case user.Token == "" && (user.ClientCertificateData == "" || user.ClientKeyData == ""):
+		log.Error(fmt.Sprintf("data needs %q, or %q and %q", kubeTokenKey, kubeClientCertKey, kubeClientKeyKey))
+		return notFoundError("specified field was not found in data")
+	}
+	// Checked here as well as before writing, so bad data fails its own entry before other outputs are written.
+	if err := errors.Join(validateKubeCluster(item.Context, cluster), validateKubeUser(item.Context, user)); err != nil {
+		log.Error("invalid kube data", logKeyError, err)
+		return fmt.Errorf("invalid kube data in secret")
+	}
+
+	config.Clusters = append(config.Clusters, kubeNamedCluster{Name: item.Context, Cluster: cluster})
+	config.Users = append(config.Users, kubeNamedUser{Name: item.Context, User: user})
+	config.Contexts = append(config.Contexts, kubeNamedContext{Name: item.Context, Context: kubeContext{
+		Cluster:   item.Context,
+		User:      item.Context,
+		Namespace: stringOr(item.Namespace, str(kubeNamespaceKey)),
+	}})
+	if config.CurrentContext == "" || item.CurrentContext {
+		config.CurrentContext = item.Context
+	}
+	log.Info("added kube context", "context", item.Context, logKeyStatus, statusSuccess)
+	return nil
+}
+
+// kubeconfigData returns a PEM value base64 encoded as kubeconfig *-data fields expect,
+// leaving values that are already encoded as they are.
+func kubeconfigData(s string) string {
+	if strings.HasPrefix(strings.TrimSpace(s), "-----BEGIN") {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	}
+	return s
+}
+
+// write validates the kubeconfig, writes it to dir and exports KUBECONFIG. It does nothing when
+// there are no contexts.
+func (config *kubeconfig) write(log *slog.Logger, out exporter, dir string) error {
+	if len(config.Contexts) == 0 {
+		return nil
+	}
+	config.APIVersion = "v1"
+	config.Kind = "Config"
+	b, err := yaml.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("unable to encode kubeconfig: %w", err)
+	}
+	if err := validateKubeconfig(b); err != nil {
+		log.Error("invalid kubeconfig", logKeyPhase, phaseWrite, logKeyError, err)
+		return fmt.Errorf("cannot build kubeconfig")
+	}
+	path := filepath.Join(dir, kubeconfigFileName)
+	if err := out.WriteFile(path, b, PermissionReadWriteOwner); err != nil {
+		log.Error("unable to write kubeconfig", logKeyPhase, phaseWrite, logKeyError, err)
+		return fmt.Errorf("cannot write kubeconfig")
+	}
+	if err := out.Export("KUBECONFIG", path); err != nil {
+		log.Error("unable to export env variable", logKeyPhase, phaseExport, "variable", "KUBECONFIG", logKeyError, err)
+		return fmt.Errorf("cannot set environment variable")
+	}
+	log.Info("wrote kubeconfig", logKeyPhase, phaseWrite, "contexts", len(config.Contexts), "file", path, "currentContext", config.CurrentContext, logKeyStatus, statusSuccess)
+	return nil
+}
+
+// validateKubeconfig parses b back as a kubeconfig and checks that every context refers to a cluster
+// and user, servers are URLs, and certificates and keys decode. Errors never include secret values.
+func validateKubeconfig(b []byte) error {
+	config := kubeconfig{}
+	if err := yaml.Unmarshal(b, &config); err != nil {
+		return fmt.Errorf("does not parse: %w", err)
+	}
+	if config.APIVersion != "v1" || config.Kind != "Config" {
+		return errors.New("expected apiVersion v1 and kind Config")
+	}
+	clusters := map[string]kubeCluster{}
+	for _, c := range config.Clusters {
+		if err := validateKubeCluster(c.Name, c.Cluster); err != nil {
+			return err
+		}
+		clusters[c.Name] = c.Cluster
+	}
+	users := map[string]kubeUser{}
+	for _, u := range config.Users {
+		if err := validateKubeUser(u.Name, u.User); err != nil {
+			return err
+		}
+		users[u.Name] = u.User
+	}
+	current := false
+	for _, c := range config.Contexts {
+		if _, ok := clusters[c.Context.Cluster]; !ok {
+			return fmt.Errorf("context %q: cluster %q not found", c.Name, c.Context.Cluster)
+		}
+		if _, ok := users[c.Context.User]; !ok {
+			return fmt.Errorf("context %q: user %q not found", c.Name, c.Context.User)
+		}
+		current = current || c.Name == config.CurrentContext
+	}
+	if !current {
+		return fmt.Errorf("current context %q not found", config.CurrentContext)
+	}
+	return nil
+}
+
+// validateKubeCluster checks that the server of cluster name is a URL and its CA decodes.
+func validateKubeCluster(name string, cluster kubeCluster) error {
+	u, err := url.Parse(cluster.Server)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("cluster %q: server is not an http or https URL", name)
+	}
+	if cluster.CertificateAuthorityData != "" {
+		pool := x509.NewCertPool()
+		if ca, err := base64.StdEncoding.DecodeString(cluster.CertificateAuthorityData); err != nil || !pool.AppendCertsFromPEM(ca) {
+			return fmt.Errorf("cluster %q: %s is not a PEM certificate", name, kubeCAKey)
+		}
+	}
+	return nil
+}
+
+// validateKubeUser checks that a client certificate of user name comes with its key, even when
+// there is a token too, and that they form a pair.
+func validateKubeUser(name string, user kubeUser) error {
+	if user.ClientCertificateData == "" && user.ClientKeyData == "" {
+		return nil
+	}
+	cert, certErr := base64.StdEncoding.DecodeString(user.ClientCertificateData)
+	key, keyErr := base64.StdEncoding.DecodeString(user.ClientKeyData)
+	if certErr != nil || keyErr != nil {
+		return fmt.Errorf("user %q: client certificate or key is not base64", name)
+	}
+	if _, err := tls.X509KeyPair(cert, key); err != nil {
+		return fmt.Errorf("user %q: client certificate and key do not form a pair", name)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package dga_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/pterm/pterm"
+	yaml "gopkg.in/yaml.v3"
+
+	dga "github.com/DelineaXPM/dsv-gitlab/dga"
+	"github.com/DelineaXPM/dsv-gitlab/dsv/dsvtest"
+)
+
+// selfSigned returns a PEM certificate and key for name.
+func selfSigned(t *testing.T, name string) (certPEM, keyPEM string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func TestRunKubeconfig(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+	ca, _ := selfSigned(t, "cluster-ca")
+	clientCert, clientKey := selfSigned(t, "deployer")
+	b64 := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.SetSecret("ci:kube:staging", map[string]interface{}{
+		"server": "https://staging.example.com:6443", "certificateAuthorityData": ca, "token": "staging-token", "namespace": "apps",
+	})
+	server.SetSecret("ci:kube:prod", map[string]interface{}{
+		"server": "https://prod.example.com", "certificateAuthorityData": b64(ca),
+		"clientCertificateData": b64(clientCert), "clientKeyData": clientKey,
+	})
+	opts := hermeticRun(t, server, map[string]string{"DSV_RETRIEVE": `[
+		{"type": "kube", "secretPath": "ci:kube:staging", "context": "staging"},
+		{"type": "kube", "secretPath": "ci:kube:prod", "context": "prod", "namespace": "deploy", "currentContext": true}
+	]`})
+
+	is.NoErr(dga.Run(context.Background(), opts))
+
+	vars := readDotEnv(t, opts)
+	is.Equal(vars, map[string]string{"KUBECONFIG": filepath.Join(opts.Root, ".kube", "config")})
+
+	b, err := os.ReadFile(vars["KUBECONFIG"])
+	is.NoErr(err)
+	var config struct {
+		APIVersion string `yaml:"apiVersion"`
+		Kind       string `yaml:"kind"`
+		Clusters   []struct {
+			Name    string            `yaml:"name"`
+			Cluster map[string]string `yaml:"cluster"`
+		} `yaml:"clusters"`
+		Contexts []struct {
+			Name    string            `yaml:"name"`
+			Context map[string]string `yaml:"context"`
+		} `yaml:"contexts"`
+		Users []struct {
+			Name string            `yaml:"name"`
+			User map[string]string `yaml:"user"`
+		} `yaml:"users"`
+		CurrentContext string `yaml:"current-context"`
+	}
+	is.NoErr(yaml.Unmarshal(b, &config))
+	is.Equal(config.APIVersion, "v1")
+	is.Equal(config.Kind, "Config")
+	is.Equal(config.CurrentContext, "prod") // Marked entry should be the current context.
+	is.Equal(len(config.Clusters), 2)
+	is.Equal(config.Clusters[0].Name, "staging")
+	is.Equal(config.Clusters[0].Cluster, map[string]string{
+		"server": "https://staging.example.com:6443", "certificate-authority-data": b64(ca),
+	}) // PEM CA data should be base64 encoded.
+	is.Equal(config.Clusters[1].Cluster["certificate-authority-data"], b64(ca)) // Encoded CA data should be kept.
+	is.Equal(config.Contexts[0].Context, map[string]string{"cluster": "staging", "user": "staging", "namespace": "apps"})
+	is.Equal(config.Contexts[1].Context, map[string]string{"cluster": "prod", "user": "prod", "namespace": "deploy"})
+	is.Equal(config.Users[0].User, map[string]string{"token": "staging-token"})
+	is.Equal(config.Users[1].User, map[string]string{
+		"client-certificate-data": b64(clientCert), "client-key-data": b64(clientKey),
+	})
+
+	info, err := os.Stat(vars["KUBECONFIG"])
+	is.NoErr(err)
+	is.Equal(info.Mode().Perm(), os.FileMode(0o600)) // Kubeconfig should only be readable by the owner.
+}
+
+func TestRunKubeconfigErrors(t *testing.T) {
+	pterm.DisableOutput()
+	ca, _ := selfSigned(t, "cluster-ca")
+	clientCert, _ := selfSigned(t, "deployer")
+	_, otherKey := selfSigned(t, "other")
+	cases := []struct {
+		name string
+		data map[string]interface{}
+	}{
+		{name: "missing server", data: map[string]interface{}{"token": "t"}},
+		{name: "missing credentials", data: map[string]interface{}{"server": "https://k8s.example.com", "clientKeyData": otherKey}},
+		{name: "invalid server", data: map[string]interface{}{"server": "k8s.example.com", "token": "t"}},
+		{name: "invalid ca", data: map[string]interface{}{"server": "https://k8s.example.com", "token": "t", "certificateAuthorityData": "bm90IGEgY2VydA=="}},
+		{name: "mismatched key", data: map[string]interface{}{
+			"server": "https://k8s.example.com", "certificateAuthorityData": ca, "clientCertificateData": clientCert, "clientKeyData": otherKey,
+		}},
+		{name: "token and certificate without key", data: map[string]interface{}{"server": "https://k8s.example.com", "token": "t", "clientCertificateData": clientCert}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			server := dsvtest.NewServer()
+			defer server.Close()
+			server.SetSecret("ci:kube", tc.data)
+			opts := hermeticRun(t, server, map[string]string{"DSV_RETRIEVE": `[{"type": "kube", "secretPath": "ci:kube", "context": "ci"}]`})
+
+			is.True(dga.Run(context.Background(), opts) != nil) // Should fail.
+			_, err := os.Stat(filepath.Join(opts.Root, ".kube", "config"))
+			is.True(os.IsNotExist(err)) // No kubeconfig should be written.
+		})
+	}
+}
+
+func TestParseRetrieveKube(t *testing.T) {
+	pterm.DisableOutput()
+	cases := []struct {
+		name     string
+		retrieve string
+		wantErr  bool
+	}{
+		{name: "valid", retrieve: `[{"type": "kube", "secretPath": "ci:kube", "context": "ci", "currentContext": true}]`},
+		{name: "missing context", retrieve: `[{"type": "kube", "secretPath": "ci:kube"}]`, wantErr: true},
+		{name: "missing secretPath", retrieve: `[{"type": "kube", "context": "ci"}]`, wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			_, err := dga.ParseRetrieve(tc.retrieve)
+			is.Equal(err != nil, tc.wantErr)
+		})
+	}
+}
+
+func TestRunKubeconfigFailsEntry(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+	clientCert, _ := selfSigned(t, "deployer")
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.SetSecret("ci:kube", map[string]interface{}{"server": "https://k8s.example.com", "token": "t", "clientCertificateData": clientCert})
+	server.SetSecret("ci:db", map[string]interface{}{"password": "s3cret"})
+
+	opts := hermeticRun(t, server, map[string]string{"DSV_RETRIEVE": `[
+		{"type": "kube", "secretPath": "ci:kube", "context": "ci"},
+		{"secretPath": "ci:db", "secretKey": "password", "outputVariable": "DB_PASSWORD"}
+	]`})
+	is.True(dga.Run(context.Background(), opts) != nil) // The certificate without a key should fail the run.
+	for _, r := range server.Requests() {
+		is.True(r.Path != "/secrets/ci:db") // The kube entry should fail before later entries run.
+	}
+}
@@ -0,0 +1,194 @@
+package dga
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+const (
+	// defaultLocalEnvFile is read in local mode when no --env-file is provided.
+	defaultLocalEnvFile = ".env"
+	// defaultDSVConfigFile is the DSV CLI config file name in the user home directory.
+	defaultDSVConfigFile = ".dsv.yml"
+	// defaultDSVProfile is the DSV CLI profile used when no --profile is provided.
+	defaultDSVProfile = "default"
+	// maskedValue replaces secret values in local output. Fixed length so nothing is learned about the value.
+	maskedValue = "********"
+)
+
+// dsvProfile is the subset of a DSV CLI profile needed to authenticate with client credentials.
+type dsvProfile struct {
+	Tenant string `yaml:"tenant"`
+	Domain string `yaml:"domain"`
+	Auth   struct {
+		Type   string `yaml:"type"`
+		Client struct {
+			ID     string `yaml:"id"`
+			Secret string `yaml:"secret"`
+		} `yaml:"client"`
+	} `yaml:"auth"`
+}
+
+// toEnvMap converts os.Environ() style KEY=VALUE pairs to a map.
+func toEnvMap(environ []string) map[string]string {
+	m := make(map[string]string, len(environ))
+	for _, kv := range environ {
+		k, v, _ := strings.Cut(kv, "=")
+		m[k] = v
+	}
+	return m
+}
+
+// loadLocalSources fills environment with values from the .env file and DSV CLI profile.
+// Keys already present in environment are never overwritten, and the .env file takes precedence over the profile.
+// Credential helpers only read the files given with --env-file, --config or --profile: they run in a
+// directory they do not control, such as a fetched repository, whose .env could set DSV_API_URL and
+// have the client secret sent elsewhere.
+func loadLocalSources(log *slog.Logger, environment map[string]string, opts Options) error {
+	log.Debug("loadLocalSources()")
+	envFile, required := opts.EnvFile, true
+	if envFile == "" && !opts.helper {
+		envFile, required = defaultLocalEnvFile, false
+	}
+	if envFile != "" {
+		envFile = opts.rootPath(envFile)
+		dotenv, err := ReadDotEnv(envFile)
+		if err != nil && (required || !errors.Is(err, os.ErrNotExist)) {
+			return fmt.Errorf("unable to read env file %s: %w", envFile, err)
+		}
+		mergeMissing(environment, dotenv)
+	}
+	if opts.helper && opts.ConfigFile == "" && opts.Profile == "" {
+		log.Debug("credential helper, skipping the default .env and dsv config", logKeyPhase, phaseConfig, logKeyStatus, statusSuccess)
+		return nil
+	}
+
+	configFile, required := opts.rootPath(opts.ConfigFile), true
+	if opts.ConfigFile == "" {
+		home, err := os.UserHomeDir()
+		if opts.Environment != nil {
+			// Injected environments are hermetic, the home directory is only taken from them.
+			home, err = environment["HOME"], nil
+		}
+		if err != nil || home == "" {
+			log.Debug("unable to find home directory, skipping dsv config", logKeyPhase, phaseConfig, logKeyError, err)
+			return nil
+		}
+		configFile, required = filepath.Join(home, defaultDSVConfigFile), false
+	}
+	profile := opts.Profile
+	if profile == "" {
+		profile = defaultDSVProfile
+	} else {
+		required = true
+	}
+	fromProfile, err := ReadProfile(configFile, profile)
+	switch {
+	case err != nil && required:
+		return fmt.Errorf("unable to read dsv config %s: %w", configFile, err)
+	case err != nil:
+		// The implicit profile is only a fallback, the credentials may well come from .env or the environment.
+		log.Debug("skipping dsv config", logKeyPhase, phaseConfig, logKeyPath, configFile, logKeyError, err)
+	}
+	if environment["DSV_CLIENT_ID_FILE"] != "" || environment["DSV_CLIENT_SECRET_FILE"] != "" || environment["DSV_CREDENTIALS_FILE"] != "" {
+		// Credentials come from files, so only the domain is taken from the profile.
+		delete(fromProfile, "DSV_CLIENT_ID")
+		delete(fromProfile, "DSV_CLIENT_SECRET")
+	}
+	mergeMissing(environment, fromProfile)
+	log.Debug("loadLocalSources() success", logKeyStatus, statusSuccess)
+	return nil
+}
+
+// rootPath resolves a relative path against opts.Root when it is set.
+func (opts *Options) rootPath(path string) string {
+	if opts.Root == "" || path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(opts.Root, path)
+}
+
+// mergeMissing copies the non-empty values from src into dst when dst does not already have a value.
+func mergeMissing(dst, src map[string]string) {
+	for k, v := range src {
+		if dst[k] == "" && v != "" {
+			dst[k] = v
+		}
+	}
+}
+
+// ReadDotEnv reads a .env file of KEY=VALUE lines.
+// Blank lines, comments and a leading "export " are ignored, and matching surrounding quotes are removed.
+func ReadDotEnv(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // callers check for os.ErrNotExist.
+	}
+	defer f.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		text = strings.TrimPrefix(text, "export ")
+		key, val, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", line)
+		}
+		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+		if len(val) >= 2 && (val[0] == '"' || val[0] == '\'') && val[len(val)-1] == val[0] {
+			val = val[1 : len(val)-1]
+		}
+		values[key] = val
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to scan %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// ReadProfile reads the named profile from a DSV CLI config file and returns it as DSV_* variables.
+func ReadProfile(path, profile string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // callers check for os.ErrNotExist.
+	}
+	profiles := make(map[string]dsvProfile)
+	if err := yaml.Unmarshal(b, &profiles); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal: %w", err)
+	}
+	p, ok := profiles[profile]
+	if !ok {
+		return nil, fmt.Errorf("profile %q not found", profile)
+	}
+	if p.Auth.Type != "" && p.Auth.Type != "clientcred" {
+		return nil, fmt.Errorf("profile %q uses auth type %q, only clientcred is supported", profile, p.Auth.Type)
+	}
+	values := map[string]string{
+		"DSV_CLIENT_ID":     p.Auth.Client.ID,
+		"DSV_CLIENT_SECRET": p.Auth.Client.Secret,
+	}
+	if p.Tenant != "" && p.Domain != "" {
+		values["DSV_DOMAIN"] = p.Tenant + "." + p.Domain
+	}
+	return values, nil
+}
+
+// OpenLocalEnvFile creates or truncates a dotenv file for local development.
+func OpenLocalEnvFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, PermissionReadWriteOwner) //nolint:nosnakecase // these are standard package values and ok to leave snakecase.
+	if err != nil {
+		return nil, fmt.Errorf("cannot open file %s: %w", path, err)
+	}
+	return f, nil
+}
@@ -0,0 +1,147 @@
+package dga_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/pterm/pterm"
+
+	dga "github.com/DelineaXPM/dsv-gitlab/dga"
+	"github.com/DelineaXPM/dsv-gitlab/dsv/dsvtest"
+)
+
+func TestReadDotEnv(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+
+	path := filepath.Join(t.TempDir(), ".env")
+	content := `
+# local credentials
+DSV_DOMAIN=example.secretsvaultcloud.com
+export DSV_CLIENT_ID="client id"
+DSV_CLIENT_SECRET='s3cr=t'
+DSV_RETRIEVE=[{"secretPath": "a:b", "secretKey": "k", "outputVariable": "V"}]
+`
+	is.NoErr(os.WriteFile(path, []byte(content), 0o600))
+
+	got, err := dga.ReadDotEnv(path)
+	is.NoErr(err) // Should read the file.
+	is.Equal(got, map[string]string{
+		"DSV_DOMAIN":        "example.secretsvaultcloud.com",
+		"DSV_CLIENT_ID":     "client id",
+		"DSV_CLIENT_SECRET": "s3cr=t",
+		"DSV_RETRIEVE":      `[{"secretPath": "a:b", "secretKey": "k", "outputVariable": "V"}]`,
+	})
+
+	is.NoErr(os.WriteFile(path, []byte("NOT A PAIR\n"), 0o600))
+	_, err = dga.ReadDotEnv(path)
+	is.True(err != nil) // Should fail on lines without '='.
+}
+
+func TestReadProfile(t *testing.T) {
+	pterm.DisableOutput()
+	path := filepath.Join(t.TempDir(), ".dsv.yml")
+	content := `
+default:
+  auth:
+    type: clientcred
+    client:
+      id: default-id
+      secret: default-secret
+  domain: secretsvaultcloud.com
+  tenant: mytenant
+password:
+  auth:
+    type: password
+    username: me
+  domain: secretsvaultcloud.com
+  tenant: mytenant
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		profile string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:    "client credentials profile",
+			profile: "default",
+			want: map[string]string{
+				"DSV_DOMAIN":        "mytenant.secretsvaultcloud.com",
+				"DSV_CLIENT_ID":     "default-id",
+				"DSV_CLIENT_SECRET": "default-secret",
+			},
+		},
+		{
+			name:    "unsupported auth type",
+			profile: "password",
+			wantErr: true,
+		},
+		{
+			name:    "missing profile",
+			profile: "nope",
+			wantErr: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			got, err := dga.ReadProfile(path, tc.profile)
+			if tc.wantErr {
+				is.True(err != nil) // Should fail.
+				return
+			}
+			is.NoErr(err)
+			is.Equal(got, tc.want) // Profile should map to DSV_* variables.
+		})
+	}
+}
+
+func TestRunLocalProfileFallback(t *testing.T) {
+	pterm.DisableOutput()
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.SetSecret("ci:db", map[string]interface{}{"password": "s3cret"})
+	passwordProfile := "default:\n  auth:\n    type: password\n    username: me\n"
+
+	cases := []struct {
+		name    string
+		config  string
+		profile string
+		wantErr bool
+	}{
+		{name: "password default profile", config: passwordProfile},
+		{name: "no default profile", config: "other:\n  auth:\n    type: clientcred\n"},
+		{name: "unreadable config", config: "not: [yaml"},
+		{name: "explicit profile", config: passwordProfile, profile: "default", wantErr: true},
+		{name: "explicit missing profile", config: passwordProfile, profile: "ci", wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			home := t.TempDir()
+			is.NoErr(os.WriteFile(filepath.Join(home, ".dsv.yml"), []byte(tc.config), 0o600))
+
+			opts := hermeticRun(t, server, map[string]string{
+				"HOME":         home,
+				"DSV_RETRIEVE": `[{"secretPath": "ci:db", "secretKey": "password", "outputVariable": "DB_PASSWORD"}]`,
+			})
+			opts.Local = true
+			opts.OutFile = "local.env"
+			opts.Profile = tc.profile
+			err := dga.Run(context.Background(), opts)
+			if tc.wantErr {
+				is.Equal(dga.KindOf(err), dga.ErrorConfig) // Explicitly requested profiles should be fatal.
+				return
+			}
+			is.NoErr(err) // The implicit profile should be skipped, the environment has the credentials.
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package dga
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pterm/pterm"
+)
+
+// Log formats accepted in DSV_LOG_FORMAT.
+const (
+	// LogFormatPretty is the colored pterm output, the default.
+	LogFormatPretty = "pretty"
+	// LogFormatPlain is the pretty output without colors, one "LEVEL message" line per event.
+	LogFormatPlain = "plain"
+	// LogFormatText is slog key=value output.
+	LogFormatText = "text"
+	// LogFormatJSON is one slog JSON object per event.
+	LogFormatJSON = "json"
+)
+
+// Attribute keys of the structured log events.
+const (
+	logKeyPhase    = "phase"    // logKeyPhase is the step of the run, such as auth or retrieve.
+	logKeyPath     = "path"     // logKeyPath is the DSV path the event is about.
+	logKeyStatus   = "status"   // logKeyStatus is success or failure for events ending a step.
+	logKeyDuration = "duration" // logKeyDuration is how long the step took.
+	logKeyError    = "error"
+)
+
+// Values of the phase attribute.
+const (
+	phaseConfig   = "config"
+	phaseAuth     = "auth"
+	phaseRetrieve = "retrieve"
+	phaseExport   = "export"
+	phaseWrite    = "write"
+	phaseStore    = "store"
+	phaseRotate   = "rotate"
+	phaseHelper   = "helper"
+)
+
+// Values of the status attribute.
+const (
+	statusSuccess = "success"
+	statusFailure = "failure"
+)
+
+// defaultLogger returns the logger used before DSV_LOG_FORMAT and DSV_LOG_LEVEL are known, and
+// for a Config that was not built by parseConfig.
+func defaultLogger() *slog.Logger {
+	return newPrettyLogger(slog.LevelInfo)
+}
+
+// log returns the logger of cfg, set by parseConfig, or the default one.
+func (cfg *Config) log() *slog.Logger {
+	if cfg.logger == nil {
+		return defaultLogger()
+	}
+	return cfg.logger
+}
+
+// NewLogger returns the logger for the DSV_LOG_FORMAT and DSV_LOG_LEVEL values format and level. The
+// pretty format prints through pterm, the other formats write to w. debug enables debug events at any
+// level, as CI_DEBUG_TRACE does.
+func NewLogger(format, level string, debug bool, w io.Writer) (*slog.Logger, error) {
+	l, err := parseLogLevel(level)
+	if err != nil {
+		return nil, fmt.Errorf("DSV_LOG_LEVEL: %w", err)
+	}
+	if debug {
+		l = slog.LevelDebug
+	}
+	logger, err := newLogger(format, l, w)
+	if err != nil {
+		return nil, fmt.Errorf("DSV_LOG_FORMAT: %w", err)
+	}
+	return logger, nil
+}
+
+// newLogger returns a logger for format and level. The pretty format prints through pterm,
+// the other formats write to w.
+func newLogger(format string, level slog.Level, w io.Writer) (*slog.Logger, error) {
+	switch strings.ToLower(format) {
+	case "", LogFormatPretty:
+		return newPrettyLogger(level), nil
+	case LogFormatPlain:
+		return slog.New(&humanHandler{level: level, w: w, mu: &sync.Mutex{}}), nil
+	case LogFormatText:
+		return slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{Level: level})), nil
+	case LogFormatJSON:
+		return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})), nil
+	default:
+		return nil, fmt.Errorf("unsupported log format %q, expected %s, %s, %s or %s", format, LogFormatPretty, LogFormatPlain, LogFormatText, LogFormatJSON)
+	}
+}
+
+func newPrettyLogger(level slog.Level) *slog.Logger {
+	return slog.New(&humanHandler{level: level, mu: &sync.Mutex{}})
+}
+
+// parseLogLevel parses DSV_LOG_LEVEL: debug, info, warn or error, info when empty.
+func parseLogLevel(s string) (slog.Level, error) {
+	level := slog.LevelInfo
+	if s == "" {
+		return level, nil
+	}
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return level, fmt.Errorf("invalid log level %q, expected debug, info, warn or error: %w", s, err)
+	}
+	return level, nil
+}
+
+// logSection starts a collapsed GitLab job log section titled header, ended by the returned func, so
+// the events in between are folded away. Sections are only written for the pretty and plain formats
+// when running in GitLab. See https://docs.gitlab.com/ee/ci/jobs/#custom-collapsible-sections
+func (cfg *Config) logSection(name, header string) func() {
+	h, ok := cfg.log().Handler().(*humanHandler)
+	if !ok || cfg.Local || !cfg.IsCI {
+		return func() {}
+	}
+	h.writeRaw(fmt.Sprintf("\x1b[0Ksection_start:%d:%s[collapsed=true]\r\x1b[0K%s\n", time.Now().Unix(), name, header))
+	return func() {
+		h.writeRaw(fmt.Sprintf("\x1b[0Ksection_end:%d:%s\r\x1b[0K\n", time.Now().Unix(), name))
+	}
+}
+
+// humanHandler is the slog handler for the pretty and plain formats. An event is printed as
+// its message, prefixed with the quoted path and followed by the error and any other attributes.
+// Info events with a success status are printed as successes.
+type humanHandler struct {
+	level  slog.Level
+	w      io.Writer // w receives plain lines, events are printed through pterm when nil.
+	mu     *sync.Mutex
+	attrs  []slog.Attr
+	prefix string // prefix is the group prefix of added attribute keys.
+}
+
+func (h *humanHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *humanHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.attrs = append([]slog.Attr(nil), h.attrs...)
+	for _, a := range attrs {
+		a.Key = h.prefix + a.Key
+		c.attrs = append(c.attrs, a)
+	}
+	return &c
+}
+
+func (h *humanHandler) WithGroup(name string) slog.Handler {
+	c := *h
+	c.prefix = h.prefix + name + "."
+	return &c
+}
+
+func (h *humanHandler) Handle(_ context.Context, r slog.Record) error {
+	var path, status, errText string
+	var extra []string
+	add := func(a slog.Attr) bool {
+		switch value := a.Value.Resolve().String(); a.Key {
+		case logKeyPath:
+			path = value
+		case logKeyStatus:
+			status = value
+		case logKeyError:
+			errText = value
+		case logKeyPhase:
+			// The message already says what is happening.
+		default:
+			if strings.ContainsAny(value, " =") {
+				value = strconv.Quote(value)
+			}
+			extra = append(extra, a.Key+"="+value)
+		}
+		return true
+	}
+	for _, a := range h.attrs {
+		add(a)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		a.Key = h.prefix + a.Key
+		return add(a)
+	})
+
+	var msg strings.Builder
+	if path != "" {
+		msg.WriteString(strconv.Quote(path) + ": ")
+	}
+	msg.WriteString(r.Message)
+	if errText != "" {
+		msg.WriteString(": " + errText)
+	}
+	if len(extra) > 0 {
+		msg.WriteString(" (" + strings.Join(extra, ", ") + ")")
+	}
+
+	printer, label := h.printer(r.Level, status)
+	if h.w == nil {
+		printer.Println(msg.String())
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := fmt.Fprintf(h.w, "%-7s %s\n", label, msg.String())
+	return err //nolint:wrapcheck // slog reports handler errors as they are.
+}
+
+// writeRaw writes s as it is, where the events are printed.
+func (h *humanHandler) writeRaw(s string) {
+	if h.w == nil {
+		pterm.Print(s)
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, _ = io.WriteString(h.w, s)
+}
+
+// printer returns the pterm printer and plain label for an event.
+func (h *humanHandler) printer(level slog.Level, status string) (*pterm.PrefixPrinter, string) {
+	switch {
+	case level >= slog.LevelError:
+		return &pterm.Error, "ERROR"
+	case level >= slog.LevelWarn:
+		return &pterm.Warning, "WARNING"
+	case level >= slog.LevelInfo && status == statusSuccess:
+		return &pterm.Success, "SUCCESS"
+	case level >= slog.LevelInfo:
+		return &pterm.Info, "INFO"
+	default:
+		// The handler already filtered by level, so print without pterm's global debug switch.
+		debug := pterm.Debug
+		debug.Debugger = false
+		return &debug, "DEBUG"
+	}
+}
@@ -0,0 +1,177 @@
+package dga_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/pterm/pterm"
+
+	dga "github.com/DelineaXPM/dsv-gitlab/dga"
+	"github.com/DelineaXPM/dsv-gitlab/dsv/dsvtest"
+)
+
+func TestRunLogEvents(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.SetSecret("ci:db", map[string]interface{}{"password": "s3cret-value"})
+
+	var buf bytes.Buffer
+	opts := hermeticRun(t, server, map[string]string{"DSV_RETRIEVE": `[
+		{"secretPath": "ci:db", "secretKey": "password", "outputVariable": "DB_PASSWORD"},
+		{"secretPath": "ci:db", "secretKey": "missing", "outputVariable": "MISSING"}
+	]`})
+	opts.Logger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	is.True(dga.Run(context.Background(), opts) != nil)      // The missing key should fail the run.
+	is.True(!strings.Contains(buf.String(), "s3cret-value")) // Secret values should never be logged.
+
+	var done, failed, summary map[string]interface{}
+	var results []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		event := map[string]interface{}{}
+		is.NoErr(json.Unmarshal([]byte(line), &event))
+		switch event["msg"] {
+		case "entry done":
+			done = event
+		case "entry failed":
+			failed = event
+		case "result":
+			results = append(results, event)
+		case "summary":
+			summary = event
+		}
+	}
+	is.True(done != nil) // The exported entry should end with a done event.
+	is.Equal(done["phase"], "retrieve")
+	is.Equal(done["path"], "ci:db")
+	is.Equal(done["type"], "secret")
+	is.Equal(done["status"], "success")
+	is.True(done["duration"] != nil)
+	is.True(failed != nil) // The missing key should end with a failed event.
+	is.Equal(failed["level"], "DEBUG")
+	is.Equal(failed["status"], "failure")
+	is.Equal(failed["error"], "specified field was not found in data")
+
+	is.Equal(len(results), 2) // Every entry should have a result event.
+	is.Equal(results[0]["key"], "password")
+	is.Equal(results[0]["variable"], "DB_PASSWORD")
+	is.Equal(results[0]["status"], "ok")
+	is.Equal(results[1]["status"], "failed")
+	is.Equal(results[1]["error"], "specified field was not found in data")
+	is.Equal(summary["entries"], float64(2))
+	is.Equal(summary["failed"], float64(1))
+}
+
+func TestRunLogFormats(t *testing.T) {
+	pterm.DisableOutput()
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.SetSecret("ci:db", map[string]interface{}{"password": "s3cret"})
+	retrieve := `[{"secretPath": "ci:db", "secretKey": "password", "outputVariable": "DB_PASSWORD"}]`
+
+	cases := []struct {
+		format string
+		level  string
+		want   []string
+		absent []string
+	}{
+		{format: "plain", want: []string{"PATH   KEY       VARIABLE     STATUS  DURATION\nci:db  password  DB_PASSWORD  ok"}, absent: []string{"DEBUG", "()"}},
+		{format: "plain", level: "debug", want: []string{`DEBUG   "ci:db": start processing (key=password)`, "DEBUG   OpenEnvFile()"}},
+		{format: "text", want: []string{`level=INFO msg=result path=ci:db type=secret key=password variable=DB_PASSWORD status=ok duration=`}},
+		{format: "json", level: "warn", absent: []string{"result", "summary"}},
+		{format: "JSON", want: []string{`"msg":"summary","entries":1,"failed":0`}, absent: []string{"section_start"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.format+"_"+tc.level, func(t *testing.T) {
+			is := is.New(t)
+			opts := hermeticRun(t, server, map[string]string{"DSV_RETRIEVE": retrieve, "DSV_LOG_FORMAT": tc.format, "DSV_LOG_LEVEL": tc.level})
+			got := captureStderr(t, func() { is.NoErr(dga.Run(context.Background(), opts)) })
+			for _, want := range tc.want {
+				is.True(strings.Contains(got, want)) // Expected log line is missing.
+			}
+			for _, absent := range tc.absent {
+				is.True(!strings.Contains(got, absent)) // Filtered log line is present.
+			}
+		})
+	}
+}
+
+func TestRunLogConfigErrors(t *testing.T) {
+	pterm.DisableOutput()
+	server := dsvtest.NewServer()
+	defer server.Close()
+	retrieve := `[{"secretPath": "ci:db", "secretKey": "password", "outputVariable": "DB_PASSWORD"}]`
+
+	for name, env := range map[string]map[string]string{
+		"format": {"DSV_RETRIEVE": retrieve, "DSV_LOG_FORMAT": "xml"},
+		"level":  {"DSV_RETRIEVE": retrieve, "DSV_LOG_LEVEL": "verbose"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			is := is.New(t)
+			err := dga.Run(context.Background(), hermeticRun(t, server, env))
+			is.True(err != nil) // Unknown log settings should be rejected.
+			is.True(strings.Contains(err.Error(), "DSV_LOG_"))
+		})
+	}
+}
+
+func TestRunLoggerIsolation(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.SetSecret("ci:a", map[string]interface{}{"password": "a"})
+	server.SetSecret("ci:b", map[string]interface{}{"password": "b"})
+
+	paths := []string{"ci:a", "ci:b"}
+	bufs := make([]bytes.Buffer, len(paths))
+	var wg sync.WaitGroup
+	for i, path := range paths {
+		opts := hermeticRun(t, server, map[string]string{"DSV_RETRIEVE": `[{"secretPath": "` + path + `", "secretKey": "password", "outputVariable": "X"}]`})
+		opts.Logger = slog.New(slog.NewJSONHandler(&bufs[i], &slog.HandlerOptions{Level: slog.LevelDebug}))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			is.NoErr(dga.Run(context.Background(), opts))
+		}()
+	}
+	wg.Wait()
+	is.True(strings.Contains(bufs[0].String(), `"path":"ci:a"`))
+	is.True(!strings.Contains(bufs[0].String(), `"path":"ci:b"`)) // Concurrent runs should log to their own logger.
+	is.True(strings.Contains(bufs[1].String(), `"path":"ci:b"`))
+	is.True(!strings.Contains(bufs[1].String(), `"path":"ci:a"`))
+
+	before := bufs[0].Len()
+	opts := hermeticRun(t, server, map[string]string{"DSV_LOG_LEVEL": "debug", "DSV_RETRIEVE": `[{"secretPath": "ci:a", "secretKey": "password", "outputVariable": "X"}]`})
+	is.NoErr(dga.Run(context.Background(), opts))
+	is.Equal(bufs[0].Len(), before)    // An injected logger should not be used by later runs.
+	is.True(!pterm.PrintDebugMessages) // Debug events should not switch on pterm debug output.
+}
+
+// captureStderr returns what fn writes to os.Stderr.
+func captureStderr(t *testing.T, fn func()) string {
+	t.Helper()
+	f, err := os.Create(filepath.Join(t.TempDir(), "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	stderr := os.Stderr
+	os.Stderr = f
+	defer func() { os.Stderr = stderr }()
+	fn()
+	b, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
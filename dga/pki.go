@@ -0,0 +1,215 @@
+package dga
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"path/filepath"
+	"time"
+
+	"github.com/DelineaXPM/dsv-gitlab/dsv"
+)
+
+const (
+	// KeyAlgorithmRSA generates a 2048 bit RSA key, the default.
+	KeyAlgorithmRSA = "rsa"
+	// KeyAlgorithmECDSA generates a P-256 ECDSA key.
+	KeyAlgorithmECDSA = "ecdsa"
+
+	rsaKeyBits = 2048
+
+	// Names of the files written to outputDir.
+	certFileName  = "cert.pem"
+	keyFileName   = "key.pem"
+	chainFileName = "chain.pem"
+)
+
+// validatePKI checks the fields of a pki entry.
+func (s *SecretToRetrieve) validatePKI() error {
+	if s.RootCAPath == "" || s.CommonName == "" {
+		return errors.New("pki entries require rootCAPath and commonName")
+	}
+	if s.OutputVariable == "" && s.OutputDir == "" {
+		return errors.New("pki entries require outputVariable, outputDir or both")
+	}
+	switch s.KeyAlgorithm {
+	case "", KeyAlgorithmRSA, KeyAlgorithmECDSA:
+		return nil
+	default:
+		return fmt.Errorf("unsupported key algorithm %q, expected %s or %s", s.KeyAlgorithm, KeyAlgorithmRSA, KeyAlgorithmECDSA)
+	}
+}
+
+// issueCertificate generates a private key, has DSV sign a certificate for it and exports the
+// certificate, key and chain. The private key never leaves the job.
+//
+// With outputDir set they are written to cert.pem, key.pem and chain.pem and the file paths are
+// exported as <VAR>_CERT_FILE, <VAR>_KEY_FILE and <VAR>_CHAIN_FILE. Otherwise the PEM is exported
+// base64 encoded as <VAR>_CERT, <VAR>_KEY and <VAR>_CHAIN, as dotenv values cannot span lines.
+// The certificate expiry is exported as <VAR>_EXPIRES_AT either way.
+func (cfg *Config) issueCertificate(ctx context.Context, client *dsv.Client, out exporter, item SecretToRetrieve) error {
+	log := cfg.log().With(logKeyPhase, phaseRetrieve, logKeyPath, item.RootCAPath, "commonName", item.CommonName)
+	log.Debug("start processing")
+	key, err := generateKey(item.KeyAlgorithm)
+	if err != nil {
+		return err
+	}
+	csr, err := certificateRequest(key, item.CommonName, item.SubjectAltNames)
+	if err != nil {
+		log.Error("unable to create certificate request", logKeyError, err)
+		return fmt.Errorf("cannot create certificate request")
+	}
+
+	signed, err := client.SignCertificate(ctx, &dsv.SigningRequest{
+		RootCAPath:      item.RootCAPath,
+		CSR:             base64.StdEncoding.EncodeToString(csr),
+		SubjectAltNames: item.SubjectAltNames,
+		TTL:             item.TTL,
+		Chain:           true,
+	})
+	if err != nil {
+		log.Error("unable to issue certificate", logKeyError, err)
+		return requestError(err, "unable to issue certificate")
+	}
+	leaf, certPEM, chainPEM, err := splitCertificates(signed)
+	if err != nil {
+		log.Error("invalid certificate", logKeyError, err)
+		return fmt.Errorf("cannot parse certificate")
+	}
+	if pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(leaf.PublicKey) {
+		log.Error("certificate does not match the generated key")
+		return fmt.Errorf("cannot parse certificate")
+	}
+	log.Info("issued certificate", "serial", leaf.SerialNumber.String(), "expires", leaf.NotAfter.Format(time.RFC3339), logKeyStatus, statusSuccess)
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("unable to encode private key: %w", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+
+	var exports [][2]string
+	if item.OutputDir != "" {
+		dir := cfg.outputPath(item.OutputDir)
+		files := [][2]string{{"_CERT_FILE", certFileName}, {"_KEY_FILE", keyFileName}}
+		contents := [][]byte{certPEM, keyPEM}
+		if len(chainPEM) > 0 {
+			files = append(files, [2]string{"_CHAIN_FILE", chainFileName})
+			contents = append(contents, chainPEM)
+		}
+		for i, f := range files {
+			path := filepath.Join(dir, f[1])
+			if err := out.WriteFile(path, contents[i], PermissionReadWriteOwner); err != nil {
+				log.Error("unable to write certificate", logKeyPhase, phaseWrite, logKeyError, err)
+				return fmt.Errorf("cannot write certificate files")
+			}
+			exports = append(exports, [2]string{f[0], path})
+		}
+	} else {
+		exports = append(exports,
+			[2]string{"_CERT", base64.StdEncoding.EncodeToString(certPEM)},
+			[2]string{"_KEY", base64.StdEncoding.EncodeToString(keyPEM)},
+		)
+		if len(chainPEM) > 0 {
+			exports = append(exports, [2]string{"_CHAIN", base64.StdEncoding.EncodeToString(chainPEM)})
+		}
+	}
+	if item.OutputVariable == "" {
+		return nil
+	}
+	exports = append(exports, [2]string{"_EXPIRES_AT", leaf.NotAfter.UTC().Format(time.RFC3339)})
+	for _, kv := range exports {
+		name, val := item.OutputVariable+kv[0], kv[1]
+		if err := out.Export(name, val); err != nil {
+			log.Error("unable to export env variable", logKeyPhase, phaseExport, "variable", name, logKeyError, err)
+			return fmt.Errorf("cannot set environment variable")
+		}
+	}
+	return nil
+}
+
+// generateKey returns a new private key for the named algorithm, RSA when empty.
+func generateKey(algorithm string) (crypto.Signer, error) {
+	var (
+		key crypto.Signer
+		err error
+	)
+	if algorithm == KeyAlgorithmECDSA {
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	} else {
+		key, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate %s key: %w", algorithm, err)
+	}
+	return key, nil
+}
+
+// certificateRequest returns a PEM encoded certificate signing request for commonName, sorting each
+// subject alternative name into a DNS name, IP address, email address or URI.
+func certificateRequest(key crypto.Signer, commonName string, subjectAltNames []string) ([]byte, error) {
+	template := &x509.CertificateRequest{Subject: pkix.Name{CommonName: commonName}}
+	for _, name := range subjectAltNames {
+		if ip := net.ParseIP(name); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+			continue
+		}
+		if u, err := url.Parse(name); err == nil && u.Scheme != "" && u.Host != "" {
+			template.URIs = append(template.URIs, u)
+			continue
+		}
+		if addr, err := mail.ParseAddress(name); err == nil && addr.Address == name {
+			template.EmailAddresses = append(template.EmailAddresses, name)
+			continue
+		}
+		template.DNSNames = append(template.DNSNames, name)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), nil
+}
+
+// splitCertificates returns the parsed leaf certificate, its PEM, and the PEM of the issuing chain.
+// The chain is made of any certificates following the leaf and the chain returned by the API.
+func splitCertificates(signed *dsv.SignedCertificate) (*x509.Certificate, []byte, []byte, error) {
+	var blocks []*pem.Block
+	for _, s := range []string{signed.Certificate, signed.Chain} {
+		rest := []byte(s)
+		for {
+			var block *pem.Block
+			if block, rest = pem.Decode(rest); block == nil {
+				break
+			}
+			if block.Type == "CERTIFICATE" {
+				blocks = append(blocks, block)
+			}
+		}
+	}
+	if len(blocks) == 0 {
+		return nil, nil, nil, errors.New("no PEM encoded certificate in response")
+	}
+	leaf, err := x509.ParseCertificate(blocks[0].Bytes)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("unable to parse certificate: %w", err)
+	}
+	var chain bytes.Buffer
+	for _, block := range blocks[1:] {
+		_ = pem.Encode(&chain, block)
+	}
+	return leaf, pem.EncodeToMemory(blocks[0]), chain.Bytes(), nil
+}
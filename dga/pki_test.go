@@ -0,0 +1,165 @@
+package dga_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/pterm/pterm"
+
+	dga "github.com/DelineaXPM/dsv-gitlab/dga"
+	"github.com/DelineaXPM/dsv-gitlab/dsv"
+	"github.com/DelineaXPM/dsv-gitlab/dsv/dsvtest"
+)
+
+// signingRequests returns the bodies of the PKI signing requests server received.
+func signingRequests(t *testing.T, server *dsvtest.Server) []dsv.SigningRequest {
+	t.Helper()
+	var requests []dsv.SigningRequest
+	for _, r := range server.Requests() {
+		if r.Path != "/pki/sign" {
+			continue
+		}
+		in := dsv.SigningRequest{}
+		if err := json.Unmarshal(r.Body, &in); err != nil {
+			t.Fatal(err)
+		}
+		requests = append(requests, in)
+	}
+	return requests
+}
+
+// readDotEnv reads the job dotenv file written by a run with opts.
+func readDotEnv(t *testing.T, opts dga.Options) map[string]string {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join(opts.Root, opts.Environment["CI_JOB_NAME"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		k, v, _ := strings.Cut(line, "=")
+		vars[k] = v
+	}
+	return vars
+}
+
+func TestRunPKIFiles(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+	server := dsvtest.NewServer()
+	defer server.Close()
+	ca := server.AddRootCA("ci-root")
+	opts := hermeticRun(t, server, map[string]string{"DSV_RETRIEVE": `[{"type": "pki", "rootCAPath": "ci-root", "commonName": "deploy.example.com",
+		"subjectAltNames": ["deploy.example.com", "10.0.0.1"], "ttl": "1h", "keyAlgorithm": "ecdsa",
+		"outputDir": "certs", "outputVariable": "DEPLOY_TLS"}]`})
+
+	is.NoErr(dga.Run(context.Background(), opts))
+
+	requests := signingRequests(t, server)
+	is.Equal(len(requests), 1)
+	is.Equal(requests[0].SubjectAltNames, []string{"deploy.example.com", "10.0.0.1"})
+	is.Equal(requests[0].TTL, "1h")
+	is.True(requests[0].Chain) // Should ask for the chain.
+
+	dir := filepath.Join(opts.Root, "certs")
+	vars := readDotEnv(t, opts)
+	is.Equal(vars["DEPLOY_TLS_CERT_FILE"], filepath.Join(dir, "cert.pem"))
+	is.Equal(vars["DEPLOY_TLS_KEY_FILE"], filepath.Join(dir, "key.pem"))
+	is.Equal(vars["DEPLOY_TLS_CHAIN_FILE"], filepath.Join(dir, "chain.pem"))
+
+	pair, err := tls.LoadX509KeyPair(vars["DEPLOY_TLS_CERT_FILE"], vars["DEPLOY_TLS_KEY_FILE"])
+	is.NoErr(err) // Certificate should match the key.
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	is.NoErr(err)
+	is.Equal(leaf.Subject.CommonName, "deploy.example.com")
+	is.Equal(leaf.DNSNames, []string{"deploy.example.com"})
+	is.Equal(leaf.IPAddresses[0].String(), "10.0.0.1")
+	is.Equal(vars["DEPLOY_TLS_EXPIRES_AT"], leaf.NotAfter.UTC().Format(time.RFC3339))
+
+	chain, err := os.ReadFile(vars["DEPLOY_TLS_CHAIN_FILE"])
+	is.NoErr(err)
+	pool := x509.NewCertPool()
+	is.True(pool.AppendCertsFromPEM(chain))
+	_, err = leaf.Verify(x509.VerifyOptions{Roots: pool, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	is.NoErr(err) // Chain should verify the certificate.
+	block, _ := pem.Decode(chain)
+	is.Equal(block.Bytes, ca.Raw) // Chain should be the test CA.
+
+	info, err := os.Stat(vars["DEPLOY_TLS_KEY_FILE"])
+	is.NoErr(err)
+	is.Equal(info.Mode().Perm(), os.FileMode(0o600)) // Key should only be readable by the owner.
+}
+
+func TestRunPKIVariables(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.AddRootCA("ci-root")
+	opts := hermeticRun(t, server, map[string]string{
+		"DSV_RETRIEVE": `[{"type": "pki", "rootCAPath": "ci-root", "commonName": "client", "ttl": "30m", "outputVariable": "CLIENT_TLS"}]`,
+	})
+
+	is.NoErr(dga.Run(context.Background(), opts))
+
+	vars := readDotEnv(t, opts)
+	decode := func(name string) []byte {
+		b, err := base64.StdEncoding.DecodeString(vars[name])
+		is.NoErr(err)
+		return b
+	}
+	_, err := tls.X509KeyPair(decode("CLIENT_TLS_CERT"), decode("CLIENT_TLS_KEY"))
+	is.NoErr(err) // Certificate should match the RSA key.
+	is.True(strings.HasPrefix(string(decode("CLIENT_TLS_CHAIN")), "-----BEGIN CERTIFICATE-----"))
+	is.True(vars["CLIENT_TLS_EXPIRES_AT"] != "")
+}
+
+func TestRunPKIFailureRemovesFiles(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.AddRootCA("ci-root")
+	opts := hermeticRun(t, server, map[string]string{"DSV_RETRIEVE": `[
+		{"type": "pki", "rootCAPath": "ci-root", "commonName": "one", "outputDir": "one"},
+		{"type": "pki", "rootCAPath": "missing-root", "commonName": "two", "outputDir": "two"}
+	]`})
+
+	is.True(dga.Run(context.Background(), opts) != nil) // Second entry should fail.
+	is.Equal(len(signingRequests(t, server)), 2)
+
+	_, err := os.Stat(filepath.Join(opts.Root, "one", "key.pem"))
+	is.True(os.IsNotExist(err)) // Files of earlier entries should be removed.
+}
+
+func TestParseRetrievePKI(t *testing.T) {
+	pterm.DisableOutput()
+	cases := []struct {
+		name     string
+		retrieve string
+		wantErr  bool
+	}{
+		{name: "valid", retrieve: `[{"type": "pki", "rootCAPath": "root", "commonName": "cn", "outputVariable": "TLS"}]`},
+		{name: "missing root", retrieve: `[{"type": "pki", "commonName": "cn", "outputVariable": "TLS"}]`, wantErr: true},
+		{name: "missing output", retrieve: `[{"type": "pki", "rootCAPath": "root", "commonName": "cn"}]`, wantErr: true},
+		{name: "bad key algorithm", retrieve: `[{"type": "pki", "rootCAPath": "root", "commonName": "cn", "outputDir": "d", "keyAlgorithm": "dsa"}]`, wantErr: true},
+		{name: "unknown type", retrieve: `[{"type": "vault", "secretPath": "a", "secretKey": "b"}]`, wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			_, err := dga.ParseRetrieve(tc.retrieve)
+			is.Equal(err != nil, tc.wantErr)
+		})
+	}
+}
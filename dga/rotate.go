@@ -0,0 +1,264 @@
+package dga
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+const (
+	// GeneratorPassword builds a random string from character classes.
+	GeneratorPassword = "password"
+	// GeneratorUUID builds a random (version 4) UUID.
+	GeneratorUUID = "uuid"
+	// GeneratorHex hex encodes Length random bytes.
+	GeneratorHex = "hex"
+	// GeneratorBase64 base64 encodes Length random bytes.
+	GeneratorBase64 = "base64"
+
+	// defaultRotateLength is the password length, or number of random bytes for hex and base64.
+	defaultRotateLength = 32
+	// uuidBytes is the size of a UUID.
+	uuidBytes = 16
+)
+
+// characterClasses are the named sets a password can be built from.
+//
+//nolint:gochecknoglobals // lookup table.
+var characterClasses = map[string]string{
+	"lower":   "abcdefghijklmnopqrstuvwxyz",
+	"upper":   "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	"digits":  "0123456789",
+	"symbols": "!#%+,-.:=?@^_~",
+}
+
+// defaultCharacterClasses are used when a password spec does not name any.
+//
+//nolint:gochecknoglobals // default value.
+var defaultCharacterClasses = []string{"lower", "upper", "digits"}
+
+// RotateSpec describes a secret key to rotate. It is read from DSV_ROTATE as JSON or YAML,
+// and fields set on the command line override it.
+//
+//nolint:tagliatelle // Here 'camel' casing is used to match DSV_RETRIEVE.
+type RotateSpec struct {
+	Path           string   `yaml:"path"`
+	Key            string   `yaml:"key"`            // Key is the data key that gets the new value.
+	Generator      string   `yaml:"generator"`      // Generator is password (default), uuid, hex or base64.
+	Length         int      `yaml:"length"`         // Length of a password, or number of random bytes for hex and base64.
+	Classes        []string `yaml:"classes"`        // Classes are the password character classes: lower, upper, digits, symbols.
+	OutputVariable string   `yaml:"outputVariable"` // OutputVariable exports the new value to the dotenv file when set.
+}
+
+// ParseRotateSpec parses a JSON or YAML rotate spec.
+func ParseRotateSpec(spec string) (RotateSpec, error) {
+	s := RotateSpec{}
+	if err := yaml.Unmarshal([]byte(spec), &s); err != nil {
+		return RotateSpec{}, fmt.Errorf("unable to unmarshal rotate spec: %w", err)
+	}
+	return s, nil
+}
+
+// override replaces the fields of s with the non-zero fields of o.
+func (s *RotateSpec) override(o RotateSpec) {
+	if o.Path != "" {
+		s.Path = o.Path
+	}
+	if o.Key != "" {
+		s.Key = o.Key
+	}
+	if o.Generator != "" {
+		s.Generator = o.Generator
+	}
+	if o.Length != 0 {
+		s.Length = o.Length
+	}
+	if len(o.Classes) > 0 {
+		s.Classes = o.Classes
+	}
+	if o.OutputVariable != "" {
+		s.OutputVariable = o.OutputVariable
+	}
+}
+
+// validate checks the spec and applies defaults.
+func (s *RotateSpec) validate() error {
+	if s.Path == "" || s.Key == "" {
+		return errors.New("rotate path and key are required")
+	}
+	if s.Generator == "" {
+		s.Generator = GeneratorPassword
+	}
+	if s.Length == 0 {
+		s.Length = defaultRotateLength
+	}
+	if s.Length < 0 {
+		return fmt.Errorf("rotate length must be positive, got %d", s.Length)
+	}
+	if s.Generator == GeneratorPassword && len(s.Classes) == 0 {
+		s.Classes = defaultCharacterClasses
+	}
+	// Checked before the secret is written, the _VERSION and _PREVIOUS_VERSION names only add a valid suffix.
+	if s.OutputVariable != "" {
+		if err := checkVariableName(s.OutputVariable); err != nil {
+			return fmt.Errorf("output variable: %w", err)
+		}
+	}
+	return nil
+}
+
+// GenerateValue returns a new random value from crypto/rand using the named generator.
+func GenerateValue(generator string, length int, classes []string) (string, error) {
+	switch generator {
+	case GeneratorPassword:
+		return generatePassword(length, classes)
+	case GeneratorUUID:
+		b, err := randomBytes(uuidBytes)
+		if err != nil {
+			return "", err
+		}
+		b[6] = (b[6] & 0x0f) | 0x40 //nolint:gomnd // version 4.
+		b[8] = (b[8] & 0x3f) | 0x80 //nolint:gomnd // RFC 4122 variant.
+		return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+	case GeneratorHex:
+		b, err := randomBytes(length)
+		return hex.EncodeToString(b), err
+	case GeneratorBase64:
+		b, err := randomBytes(length)
+		return base64.StdEncoding.EncodeToString(b), err
+	default:
+		return "", fmt.Errorf("unsupported generator %q, expected %s, %s, %s or %s", generator, GeneratorPassword, GeneratorUUID, GeneratorHex, GeneratorBase64)
+	}
+}
+
+// generatePassword returns a password of length characters containing at least one character of each class.
+func generatePassword(length int, classes []string) (string, error) {
+	if length < len(classes) {
+		return "", fmt.Errorf("length %d is too short to include all %d character classes", length, len(classes))
+	}
+	var all strings.Builder
+	password := make([]byte, 0, length)
+	for _, class := range classes {
+		chars, ok := characterClasses[class]
+		if !ok {
+			return "", fmt.Errorf("unknown character class %q, expected lower, upper, digits or symbols", class)
+		}
+		all.WriteString(chars)
+		c, err := randomChar(chars)
+		if err != nil {
+			return "", err
+		}
+		password = append(password, c)
+	}
+	for len(password) < length {
+		c, err := randomChar(all.String())
+		if err != nil {
+			return "", err
+		}
+		password = append(password, c)
+	}
+	// Shuffle so the guaranteed characters are not always first.
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return "", fmt.Errorf("unable to generate random number: %w", err)
+		}
+		password[i], password[j.Int64()] = password[j.Int64()], password[i]
+	}
+	return string(password), nil
+}
+
+func randomChar(chars string) (byte, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(len(chars))))
+	if err != nil {
+		return 0, fmt.Errorf("unable to generate random number: %w", err)
+	}
+	return chars[n.Int64()], nil
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return nil, fmt.Errorf("unable to generate random bytes: %w", err)
+	}
+	return b, nil
+}
+
+// Rotate writes a new random value for one key of a secret as described by DSV_ROTATE and the
+// overrides from the command line. Other keys are kept. The new value is exported when an output
+// variable is set, along with the previous and new version numbers.
+func Rotate(ctx context.Context, opts Options, overrides RotateSpec) error {
+	cfg, err := parseConfig(opts)
+	if err != nil {
+		return configError(err)
+	}
+	cfg.logDebug()
+
+	ctx, cancel := cfg.withTimeout(ctx)
+	defer cancel()
+
+	spec := RotateSpec{}
+	if cfg.RotateEnv != "" {
+		if spec, err = ParseRotateSpec(cfg.RotateEnv); err != nil {
+			return configError(err)
+		}
+	}
+	spec.override(overrides)
+	if err := spec.validate(); err != nil {
+		return configError(err)
+	}
+	value, err := GenerateValue(spec.Generator, spec.Length, spec.Classes)
+	if err != nil {
+		return configError(err)
+	}
+
+	// Open the output first, so a rotated value is never lost because the dotenv file cannot be written.
+	var out exporter
+	if spec.OutputVariable != "" {
+		var closeOut func()
+		if out, closeOut, err = cfg.openExporter(opts); err != nil {
+			return err
+		}
+		defer closeOut()
+	}
+
+	client, err := cfg.connect(ctx)
+	if err != nil {
+		return err
+	}
+	store := &StoreSpec{Path: spec.Path, Mode: StoreModeUpsert, Merge: true}
+	previous, stored, err := writeSecret(ctx, cfg.log(), client, store, map[string]interface{}{spec.Key: value})
+	if err != nil {
+		cfg.log().Error("unable to rotate key", logKeyPhase, phaseRotate, logKeyPath, spec.Path, "key", spec.Key, logKeyStatus, statusFailure, logKeyError, err)
+		return requestError(err, "unable to rotate secret")
+	}
+	previousVersion := "none"
+	if previous != nil {
+		previousVersion = previous.Version
+	}
+	cfg.log().Info("rotated key", logKeyPhase, phaseRotate, logKeyPath, spec.Path, "key", spec.Key, "previousVersion", previousVersion, "version", stored.Version, logKeyStatus, statusSuccess)
+
+	if out == nil {
+		return nil
+	}
+	for _, kv := range [][2]string{
+		{spec.OutputVariable, value},
+		{spec.OutputVariable + "_PREVIOUS_VERSION", previousVersion},
+		{spec.OutputVariable + "_VERSION", stored.Version},
+	} {
+		key, val := kv[0], kv[1]
+		if err := out.Export(key, val); err != nil {
+			cfg.log().Error("unable to export env variable", logKeyPhase, phaseExport, "variable", key, logKeyError, err)
+			return fmt.Errorf("cannot set environment variable")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package dga_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/pterm/pterm"
+
+	dga "github.com/DelineaXPM/dsv-gitlab/dga"
+	"github.com/DelineaXPM/dsv-gitlab/dsv"
+	"github.com/DelineaXPM/dsv-gitlab/dsv/dsvtest"
+)
+
+func TestGenerateValue(t *testing.T) {
+	cases := []struct {
+		name      string
+		generator string
+		length    int
+		classes   []string
+		check     func(is *is.I, v string)
+		wantErr   bool
+	}{
+		{
+			name:      "password with all classes",
+			generator: dga.GeneratorPassword,
+			length:    8,
+			classes:   []string{"lower", "upper", "digits", "symbols"},
+			check: func(is *is.I, v string) {
+				is.Equal(len(v), 8)
+				is.True(regexp.MustCompile(`[a-z]`).MatchString(v))                  // Should contain lower case.
+				is.True(regexp.MustCompile(`[A-Z]`).MatchString(v))                  // Should contain upper case.
+				is.True(regexp.MustCompile(`[0-9]`).MatchString(v))                  // Should contain digits.
+				is.True(regexp.MustCompile(`[^a-zA-Z0-9]`).MatchString(v))           // Should contain symbols.
+				is.True(regexp.MustCompile(`^[!#%+,\-.:=?@^_~\w]+$`).MatchString(v)) // Should contain nothing else.
+			},
+		},
+		{
+			name:      "digits only",
+			generator: dga.GeneratorPassword,
+			length:    12,
+			classes:   []string{"digits"},
+			check: func(is *is.I, v string) {
+				is.True(regexp.MustCompile(`^[0-9]{12}$`).MatchString(v))
+			},
+		},
+		{
+			name:      "uuid",
+			generator: dga.GeneratorUUID,
+			check: func(is *is.I, v string) {
+				is.True(regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(v))
+			},
+		},
+		{
+			name:      "hex",
+			generator: dga.GeneratorHex,
+			length:    16,
+			check: func(is *is.I, v string) {
+				b, err := hex.DecodeString(v)
+				is.NoErr(err)
+				is.Equal(len(b), 16)
+			},
+		},
+		{
+			name:      "base64",
+			generator: dga.GeneratorBase64,
+			length:    24,
+			check: func(is *is.I, v string) {
+				b, err := base64.StdEncoding.DecodeString(v)
+				is.NoErr(err)
+				is.Equal(len(b), 24)
+			},
+		},
+		{name: "too short for classes", generator: dga.GeneratorPassword, length: 2, classes: []string{"lower", "upper", "digits"}, wantErr: true},
+		{name: "unknown class", generator: dga.GeneratorPassword, length: 8, classes: []string{"emoji"}, wantErr: true},
+		{name: "unknown generator", generator: "words", length: 8, wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			v, err := dga.GenerateValue(tc.generator, tc.length, tc.classes)
+			if tc.wantErr {
+				is.True(err != nil) // Should reject the settings.
+				return
+			}
+			is.NoErr(err)
+			tc.check(is, v)
+
+			again, err := dga.GenerateValue(tc.generator, tc.length, tc.classes)
+			is.NoErr(err)
+			is.True(v != again) // Values should be random.
+		})
+	}
+}
+
+func TestRotate(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+
+	server := dsvtest.NewServer()
+	defer server.Close()
+	_, err := server.Client().CreateSecret(context.Background(), "ci:app", &dsv.SecretInput{Description: "app", Data: map[string]interface{}{"user": "app", "password": "old"}})
+	is.NoErr(err)
+	opts := hermeticRun(t, server, map[string]string{"DSV_ROTATE": `{"path": "ci:app", "key": "password", "length": 20}`})
+
+	err = dga.Rotate(context.Background(), opts, dga.RotateSpec{OutputVariable: "app_password"})
+	is.NoErr(err)
+
+	rotated := server.Secret("ci:app")
+	is.Equal(rotated.Version, "2")
+	is.Equal(rotated.Description, "app")
+	is.Equal(rotated.Data["user"], "app") // Other keys should be kept.
+	password, ok := rotated.Data["password"].(string)
+	is.True(ok)
+	is.Equal(len(password), 20)
+	is.True(password != "old") // Value should be rotated.
+
+	envFile, err := os.ReadFile(filepath.Join(opts.Root, "dsv_secrets"))
+	is.NoErr(err)
+	is.Equal(strings.Split(strings.TrimSpace(string(envFile)), "\n"), []string{
+		"APP_PASSWORD=" + password,
+		"APP_PASSWORD_PREVIOUS_VERSION=1",
+		"APP_PASSWORD_VERSION=2",
+	})
+}
+
+func TestRotateInvalidOutputVariable(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.SetSecret("ci:app", map[string]interface{}{"password": "old"})
+	opts := hermeticRun(t, server, map[string]string{"DSV_ROTATE": `{"path": "ci:app", "key": "password"}`})
+
+	err := dga.Rotate(context.Background(), opts, dga.RotateSpec{OutputVariable: "db-password"})
+	is.Equal(dga.KindOf(err), dga.ErrorConfig)
+	is.Equal(len(server.Versions("ci:app")), 1) // No new value should be written that cannot be exported.
+}
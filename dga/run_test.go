@@ -0,0 +1,241 @@
+package dga_test
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/pterm/pterm"
+
+	dga "github.com/DelineaXPM/dsv-gitlab/dga"
+	"github.com/DelineaXPM/dsv-gitlab/dsv/dsvtest"
+)
+
+func TestRunCancelledRemovesPartialOutput(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.SetSecret("fast", map[string]interface{}{"key": "value"})
+	server.Fail(dsvtest.Fault{Path: "/secrets/slow", Delay: time.Minute}) // Hang until the client gives up.
+
+	opts := hermeticRun(t, server, map[string]string{
+		"DSV_TOTAL_TIMEOUT": "200ms",
+		"DSV_RETRIEVE": `[
+			{"secretPath": "fast", "secretKey": "key", "outputVariable": "FAST"},
+			{"secretPath": "slow", "secretKey": "key", "outputVariable": "SLOW"}
+		]`,
+	})
+	envFile := filepath.Join(opts.Root, "dsv_secrets")
+	is.NoErr(os.WriteFile(envFile, []byte("EXISTING=1\n"), 0o600))
+
+	err := dga.Run(context.Background(), opts)
+	is.True(err != nil) // Should fail once the total timeout passes.
+
+	got, err := os.ReadFile(envFile)
+	is.NoErr(err)
+	is.Equal(string(got), "EXISTING=1\n") // Partial output should be removed, existing content kept.
+}
+
+func TestRunTokenCache(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.SetSecret("a", map[string]interface{}{"key": "value"})
+	tokenCalls := func() int {
+		n := 0
+		for _, r := range server.Requests() {
+			if r.Path == "/token" {
+				n++
+			}
+		}
+		return n
+	}
+	opts := hermeticRun(t, server, map[string]string{
+		"CI_PIPELINE_ID":  "1001",
+		"DSV_TOKEN_CACHE": "true",
+		"DSV_RETRIEVE":    `[{"secretPath": "a", "secretKey": "key", "outputVariable": "A"}]`,
+	})
+
+	is.NoErr(dga.Run(context.Background(), opts))
+	is.NoErr(dga.Run(context.Background(), opts))
+	is.Equal(tokenCalls(), 1) // Second job in the pipeline should reuse the cached token.
+
+	files, err := filepath.Glob(filepath.Join(opts.Root, ".dsv-cache", "token-1001-*.bin"))
+	is.NoErr(err)
+	is.Equal(len(files), 1) // The cache file should be named after the pipeline.
+	cached, err := os.ReadFile(files[0])
+	is.NoErr(err)
+	is.True(!strings.Contains(string(cached), "access-")) // Cache should be encrypted.
+
+	server.AddClient(dsvtest.ClientID, "other-secret")
+	opts.Environment["DSV_CLIENT_SECRET"] = "other-secret"
+	is.NoErr(dga.Run(context.Background(), opts))
+	is.Equal(tokenCalls(), 2) // Other credentials cannot decrypt the cache.
+
+	opts.Environment["CI_PIPELINE_ID"] = "1002"
+	is.NoErr(dga.Run(context.Background(), opts))
+	is.Equal(tokenCalls(), 3) // Other pipelines should not share the cache.
+}
+
+func TestRunTokenCacheCredentialSets(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.SetSecret("a", map[string]interface{}{"key": "value"})
+	server.AddClient("other-id", "other-secret")
+	opts := hermeticRun(t, server, map[string]string{
+		"CI_PIPELINE_ID":  "1001",
+		"DSV_TOKEN_CACHE": "true",
+		"DSV_RETRIEVE":    `[{"secretPath": "a", "secretKey": "key", "outputVariable": "A"}]`,
+	})
+	other := dga.Options{Environment: maps.Clone(opts.Environment), HTTPClient: opts.HTTPClient, Root: opts.Root}
+	other.Environment["DSV_CLIENT_ID"] = "other-id"
+	other.Environment["DSV_CLIENT_SECRET"] = "other-secret"
+
+	// Jobs of one pipeline alternating between two credential sets.
+	for _, o := range []dga.Options{opts, other, opts, other} {
+		is.NoErr(dga.Run(context.Background(), o))
+	}
+	grants := map[string]int{}
+	for _, r := range server.Requests() {
+		if r.Path == "/token" {
+			body := map[string]string{}
+			is.NoErr(json.Unmarshal(r.Body, &body))
+			grants[body["client_id"]]++
+		}
+	}
+	is.Equal(grants, map[string]int{dsvtest.ClientID: 1, "other-id": 1}) // Each credential set should keep its own cache.
+
+	files, err := filepath.Glob(filepath.Join(opts.Root, ".dsv-cache", "token-1001-*.bin"))
+	is.NoErr(err)
+	is.Equal(len(files), 2)
+}
+
+//nolint:gochecknoglobals // test flag.
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// hermeticRun returns Options running against server with only environment, writing to a temporary root.
+func hermeticRun(t *testing.T, server *dsvtest.Server, environment map[string]string) dga.Options {
+	t.Helper()
+	env := map[string]string{
+		"GITLAB_CI":         "true",
+		"CI_JOB_NAME":       "dsv_secrets",
+		"DSV_API_URL":       server.BaseURL(),
+		"DSV_CLIENT_ID":     dsvtest.ClientID,
+		"DSV_CLIENT_SECRET": dsvtest.ClientSecret,
+	}
+	for k, v := range environment {
+		env[k] = v
+	}
+	return dga.Options{Environment: env, HTTPClient: server.Server.Client(), Root: t.TempDir()}
+}
+
+// golden compares got with testdata/name.golden, rewriting the file when -update is set.
+func golden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("%s does not match, run go test -update to accept:\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+func TestRunHermetic(t *testing.T) {
+	pterm.DisableOutput()
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.SetSecret("ci:db", map[string]interface{}{"user": "app", "password": `p@ss word="x"`})
+	server.SetSecret("ci:api", map[string]interface{}{"token": "abc.def-123", "url": "https://api.example.com/v1?x=1&y=2"})
+
+	cases := []struct {
+		name     string
+		retrieve string
+		existing string // existing is the dotenv file content before the run.
+		setup    func(*dga.Options)
+		wantErr  bool
+	}{
+		{name: "multi_secret", retrieve: `[
+			{"secretPath": "ci:db", "secretKey": "user", "outputVariable": "db_user"},
+			{"secretPath": "ci:db", "secretKey": "password", "outputVariable": "DB_PASSWORD"},
+			{"secretPath": "ci:api", "secretKey": "token", "outputVariable": "API_TOKEN"},
+			{"secretPath": "ci:api", "secretKey": "url", "outputVariable": "API_URL"}
+		]`},
+		{name: "appends_existing", existing: "FROM_EARLIER_STEP=1\n", retrieve: `[
+			{"secretPath": "ci:api", "secretKey": "token", "outputVariable": "API_TOKEN"}
+		]`},
+		{name: "missing_key", existing: "FROM_EARLIER_STEP=1\n", wantErr: true, retrieve: `[
+			{"secretPath": "ci:api", "secretKey": "token", "outputVariable": "API_TOKEN"},
+			{"secretPath": "ci:db", "secretKey": "missing", "outputVariable": "MISSING"}
+		]`},
+		{name: "missing_secret", wantErr: true, retrieve: `[{"secretPath": "ci:nope", "secretKey": "token", "outputVariable": "NOPE"}]`},
+		{name: "auth_failure", wantErr: true, retrieve: `[{"secretPath": "ci:api", "secretKey": "token", "outputVariable": "API_TOKEN"}]`,
+			setup: func(opts *dga.Options) { opts.Environment["DSV_CLIENT_SECRET"] = "wrong" }},
+		{name: "permission_denied", wantErr: true, retrieve: `[{"secretPath": "ci:api", "secretKey": "token", "outputVariable": "API_TOKEN"}]`,
+			setup: func(opts *dga.Options) {
+				server.Fail(dsvtest.Fault{Path: "/secrets/ci:api", StatusCode: http.StatusForbidden, Message: "denied", Times: 1})
+			}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			opts := hermeticRun(t, server, map[string]string{"DSV_RETRIEVE": tc.retrieve})
+			envFile := filepath.Join(opts.Root, "dsv_secrets")
+			if tc.existing != "" {
+				is.NoErr(os.WriteFile(envFile, []byte(tc.existing), 0o600))
+			}
+			if tc.setup != nil {
+				tc.setup(&opts)
+			}
+
+			err := dga.Run(context.Background(), opts)
+			is.Equal(err != nil, tc.wantErr)
+
+			got, readErr := os.ReadFile(envFile)
+			if !os.IsNotExist(readErr) {
+				is.NoErr(readErr)
+			}
+			golden(t, "run_"+tc.name+".env", string(got))
+		})
+	}
+}
+
+func TestRunHermeticLocal(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.SetSecret("ci:api", map[string]interface{}{"token": "abc.def-123"})
+
+	opts := hermeticRun(t, server, nil)
+	opts.Environment["GITLAB_CI"] = ""
+	opts.OutFile = "local.env"
+	is.NoErr(os.WriteFile(filepath.Join(opts.Root, ".env"),
+		[]byte(`DSV_RETRIEVE='[{"secretPath": "ci:api", "secretKey": "token", "outputVariable": "API_TOKEN"}]'`+"\n"), 0o600))
+
+	is.NoErr(dga.Run(context.Background(), opts)) // .env and --out should resolve against the root.
+	got, err := os.ReadFile(filepath.Join(opts.Root, "local.env"))
+	is.NoErr(err)
+	golden(t, "run_local.env", string(got))
+}
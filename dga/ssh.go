@@ -0,0 +1,270 @@
+package dga
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/DelineaXPM/dsv-gitlab/dsv"
+)
+
+const (
+	// sshKeyType is the OpenSSH name of the generated key type.
+	sshKeyType = "ssh-ed25519"
+	// sshCertType is the OpenSSH name of a certificate for sshKeyType.
+	sshCertType = "ssh-ed25519-cert-v01@openssh.com"
+	// sshKeyComment is the comment stored with the generated key.
+	sshKeyComment = "dsv-gitlab"
+
+	// Names of the files written to outputDir.
+	sshKeyFileName        = "id_ed25519"
+	sshCertFileName       = "id_ed25519-cert.pub"
+	sshKnownHostsFileName = "known_hosts"
+	sshConfigFileName     = "ssh_config"
+)
+
+// validateSSH checks the fields of an ssh entry.
+func (s *SecretToRetrieve) validateSSH() error {
+	if s.RootCAPath == "" || len(s.Principals) == 0 {
+		return errors.New("ssh entries require rootCAPath and principals")
+	}
+	if s.OutputDir == "" {
+		return errors.New("ssh entries require outputDir")
+	}
+	if s.HostCAKey != "" && len(s.Hosts) == 0 {
+		return errors.New("ssh entries with hostCAKey require hosts")
+	}
+	return nil
+}
+
+// signSSHKey generates an ephemeral ed25519 key pair, has DSV sign the public key for the entry's
+// principals and writes the private key and certificate to outputDir. When hosts are set an ssh
+// config file using them is written as well, and a known_hosts file trusting hostCAKey for them.
+// The file paths are exported as <VAR>_KEY_FILE, <VAR>_CERT_FILE, <VAR>_KNOWN_HOSTS_FILE and
+// <VAR>_CONFIG_FILE, and the certificate expiry as <VAR>_EXPIRES_AT.
+func (cfg *Config) signSSHKey(ctx context.Context, client *dsv.Client, out exporter, item SecretToRetrieve) error {
+	log := cfg.log().With(logKeyPhase, phaseRetrieve, logKeyPath, item.RootCAPath, "principals", strings.Join(item.Principals, ","))
+	log.Debug("start processing")
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return fmt.Errorf("unable to generate ssh key: %w", err)
+	}
+
+	signed, err := client.SignSSHKey(ctx, &dsv.SSHSigningRequest{
+		RootCAPath: item.RootCAPath,
+		LeafCAPath: item.LeafCAPath,
+		PublicKey:  marshalAuthorizedKey(pub),
+		Principals: item.Principals,
+		TTL:        item.TTL,
+	})
+	if err != nil {
+		log.Error("unable to sign ssh key", logKeyError, err)
+		return requestError(err, "unable to sign ssh key")
+	}
+	expiresAt, err := parseSSHCertificate(signed.Certificate, pub)
+	if err != nil {
+		log.Error("invalid ssh certificate", logKeyError, err)
+		return fmt.Errorf("cannot parse ssh certificate")
+	}
+	log.Info("signed ssh key", "expires", formatSSHExpiry(expiresAt), logKeyStatus, statusSuccess)
+
+	dir := cfg.outputPath(item.OutputDir)
+	keyFile := filepath.Join(dir, sshKeyFileName)
+	certFile := filepath.Join(dir, sshCertFileName)
+	files := []sshFile{
+		{"_KEY_FILE", keyFile, marshalOpenSSHPrivateKey(pub, priv)},
+		{"_CERT_FILE", certFile, []byte(strings.TrimSpace(signed.Certificate) + "\n")},
+	}
+	knownHostsFile := ""
+	if item.HostCAKey != "" {
+		knownHostsFile = filepath.Join(dir, sshKnownHostsFileName)
+		line := fmt.Sprintf("@cert-authority %s %s\n", strings.Join(item.Hosts, ","), strings.TrimSpace(item.HostCAKey))
+		files = append(files, sshFile{"_KNOWN_HOSTS_FILE", knownHostsFile, []byte(line)})
+	}
+	if len(item.Hosts) > 0 {
+		files = append(files, sshFile{"_CONFIG_FILE", filepath.Join(dir, sshConfigFileName), sshConfig(item.Hosts, keyFile, certFile, knownHostsFile)})
+	}
+
+	for _, f := range files {
+		if err := out.WriteFile(f.path, f.data, PermissionReadWriteOwner); err != nil {
+			log.Error("unable to write ssh files", logKeyPhase, phaseWrite, logKeyError, err)
+			return fmt.Errorf("cannot write ssh files")
+		}
+	}
+	if item.OutputVariable == "" {
+		return nil
+	}
+	exports := make([][2]string, 0, len(files)+1)
+	for _, f := range files {
+		exports = append(exports, [2]string{f.suffix, f.path})
+	}
+	exports = append(exports, [2]string{"_EXPIRES_AT", formatSSHExpiry(expiresAt)})
+	for _, kv := range exports {
+		name, val := item.OutputVariable+kv[0], kv[1]
+		if err := out.Export(name, val); err != nil {
+			log.Error("unable to export env variable", logKeyPhase, phaseExport, "variable", name, logKeyError, err)
+			return fmt.Errorf("cannot set environment variable")
+		}
+	}
+	return nil
+}
+
+// sshFile is a file written by signSSHKey and the suffix of the variable exporting its path.
+type sshFile struct {
+	suffix, path string
+	data         []byte
+}
+
+// sshConfig returns an ssh_config snippet using the key and certificate for hosts.
+func sshConfig(hosts []string, keyFile, certFile, knownHostsFile string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Host %s\n", strings.Join(hosts, " "))
+	fmt.Fprintf(&b, "  IdentityFile %s\n", quoteSSHConfig(keyFile))
+	fmt.Fprintf(&b, "  CertificateFile %s\n", quoteSSHConfig(certFile))
+	b.WriteString("  IdentitiesOnly yes\n")
+	if knownHostsFile != "" {
+		fmt.Fprintf(&b, "  UserKnownHostsFile %s\n", quoteSSHConfig(knownHostsFile))
+	}
+	return []byte(b.String())
+}
+
+func quoteSSHConfig(s string) string {
+	if strings.ContainsAny(s, " \t") {
+		return `"` + s + `"`
+	}
+	return s
+}
+
+// formatSSHExpiry formats a certificate expiry, which is the zero time for certificates that never expire.
+func formatSSHExpiry(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// marshalAuthorizedKey returns pub in authorized_keys format.
+func marshalAuthorizedKey(pub ed25519.PublicKey) string {
+	return sshKeyType + " " + base64.StdEncoding.EncodeToString(sshPublicKeyBlob(pub)) + " " + sshKeyComment
+}
+
+func sshPublicKeyBlob(pub ed25519.PublicKey) []byte {
+	var b bytes.Buffer
+	writeSSHString(&b, []byte(sshKeyType))
+	writeSSHString(&b, pub)
+	return b.Bytes()
+}
+
+// marshalOpenSSHPrivateKey returns priv in the unencrypted OpenSSH private key format read by ssh.
+func marshalOpenSSHPrivateKey(pub ed25519.PublicKey, priv ed25519.PrivateKey) []byte {
+	var check [4]byte
+	_, _ = rand.Read(check[:])
+
+	var private bytes.Buffer
+	private.Write(check[:])
+	private.Write(check[:])
+	writeSSHString(&private, []byte(sshKeyType))
+	writeSSHString(&private, pub)
+	writeSSHString(&private, priv)
+	writeSSHString(&private, []byte(sshKeyComment))
+	// Pad to the cipher block size, 8 for the none cipher.
+	for i := byte(1); private.Len()%8 != 0; i++ {
+		private.WriteByte(i)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("openssh-key-v1\x00")
+	writeSSHString(&b, []byte("none")) // cipher
+	writeSSHString(&b, []byte("none")) // kdf
+	writeSSHString(&b, nil)            // kdf options
+	_ = binary.Write(&b, binary.BigEndian, uint32(1))
+	writeSSHString(&b, sshPublicKeyBlob(pub))
+	writeSSHString(&b, private.Bytes())
+	return pem.EncodeToMemory(&pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: b.Bytes()})
+}
+
+func writeSSHString(b *bytes.Buffer, s []byte) {
+	_ = binary.Write(b, binary.BigEndian, uint32(len(s)))
+	b.Write(s)
+}
+
+// parseSSHCertificate checks that cert, in authorized_keys format, certifies pub and returns
+// when it expires, the zero time if it does not.
+func parseSSHCertificate(cert string, pub ed25519.PublicKey) (time.Time, error) {
+	fields := strings.Fields(cert)
+	if len(fields) < 2 || fields[0] != sshCertType {
+		return time.Time{}, fmt.Errorf("expected a %s certificate", sshCertType)
+	}
+	blob, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unable to decode certificate: %w", err)
+	}
+	r := sshReader(blob)
+	certType := r.string()
+	r.string() // nonce
+	key := r.string()
+	r.uint64() // serial
+	r.uint32() // type
+	r.string() // key id
+	r.string() // principals
+	r.uint64() // valid after
+	validBefore := r.uint64()
+	if r == nil {
+		return time.Time{}, errors.New("truncated certificate")
+	}
+	if string(certType) != sshCertType {
+		return time.Time{}, fmt.Errorf("certificate type %q does not match %s", certType, sshCertType)
+	}
+	if !bytes.Equal(key, pub) {
+		return time.Time{}, errors.New("certificate is not for the generated key")
+	}
+	if validBefore == math.MaxUint64 || validBefore > math.MaxInt64 {
+		return time.Time{}, nil
+	}
+	return time.Unix(int64(validBefore), 0), nil
+}
+
+// sshReader reads SSH wire format values, becoming nil once it runs out of data.
+type sshReader []byte
+
+func (r *sshReader) next(n int) []byte {
+	if *r == nil || len(*r) < n {
+		*r = nil
+		return nil
+	}
+	b := (*r)[:n]
+	*r = (*r)[n:]
+	return b
+}
+
+func (r *sshReader) uint32() uint32 {
+	if b := r.next(4); b != nil { //nolint:gomnd // uint32 size.
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *sshReader) uint64() uint64 {
+	if b := r.next(8); b != nil { //nolint:gomnd // uint64 size.
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *sshReader) string() []byte {
+	n := r.uint32()
+	if *r == nil {
+		return nil
+	}
+	return r.next(int(n))
+}
@@ -0,0 +1,120 @@
+package dga_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/pterm/pterm"
+
+	dga "github.com/DelineaXPM/dsv-gitlab/dga"
+	"github.com/DelineaXPM/dsv-gitlab/dsv"
+	"github.com/DelineaXPM/dsv-gitlab/dsv/dsvtest"
+)
+
+// sshSigner returns a dsvtest.Server SSHSigner signing keys with a throwaway CA using ssh-keygen.
+func sshSigner(t *testing.T) func(in *dsv.SSHSigningRequest) (string, error) {
+	t.Helper()
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen not found")
+	}
+	dir := t.TempDir()
+	caKey := filepath.Join(dir, "ca")
+	if out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", caKey).CombinedOutput(); err != nil {
+		t.Fatalf("ssh-keygen: %v: %s", err, out)
+	}
+
+	return func(in *dsv.SSHSigningRequest) (string, error) {
+		pubFile := filepath.Join(dir, "key.pub")
+		if err := os.WriteFile(pubFile, []byte(in.PublicKey+"\n"), 0o600); err != nil {
+			return "", err
+		}
+		out, err := exec.Command("ssh-keygen", "-q", "-s", caKey, "-I", "test", "-n", strings.Join(in.Principals, ","), "-V", "+"+in.TTL, pubFile).CombinedOutput()
+		if err != nil {
+			return "", fmt.Errorf("ssh-keygen: %w: %s", err, out)
+		}
+		cert, err := os.ReadFile(filepath.Join(dir, "key-cert.pub"))
+		return string(cert), err
+	}
+}
+
+func TestRunSSH(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.SSHSigner = sshSigner(t)
+	opts := hermeticRun(t, server, map[string]string{"DSV_RETRIEVE": `[{"type": "ssh", "rootCAPath": "ssh-root", "principals": ["deploy", "ubuntu"], "ttl": "1h",
+		"hosts": ["*.fleet.internal"], "hostCAKey": "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl host-ca",
+		"outputDir": "ssh", "outputVariable": "FLEET_SSH"}]`})
+
+	is.NoErr(dga.Run(context.Background(), opts))
+
+	var requests []dsv.SSHSigningRequest
+	for _, r := range server.Requests() {
+		if r.Path == "/pki/ssh-cert" {
+			in := dsv.SSHSigningRequest{}
+			is.NoErr(json.Unmarshal(r.Body, &in))
+			requests = append(requests, in)
+		}
+	}
+	is.Equal(len(requests), 1)
+	is.Equal(requests[0].Principals, []string{"deploy", "ubuntu"})
+	is.True(strings.HasPrefix(requests[0].PublicKey, "ssh-ed25519 ")) // Should send the public key only.
+
+	dir := filepath.Join(opts.Root, "ssh")
+	vars := readDotEnv(t, opts)
+	is.Equal(vars["FLEET_SSH_KEY_FILE"], filepath.Join(dir, "id_ed25519"))
+	is.Equal(vars["FLEET_SSH_CERT_FILE"], filepath.Join(dir, "id_ed25519-cert.pub"))
+	is.Equal(vars["FLEET_SSH_KNOWN_HOSTS_FILE"], filepath.Join(dir, "known_hosts"))
+	is.Equal(vars["FLEET_SSH_CONFIG_FILE"], filepath.Join(dir, "ssh_config"))
+	is.True(vars["FLEET_SSH_EXPIRES_AT"] != "never")
+
+	for _, name := range []string{"FLEET_SSH_KEY_FILE", "FLEET_SSH_CERT_FILE", "FLEET_SSH_KNOWN_HOSTS_FILE", "FLEET_SSH_CONFIG_FILE"} {
+		info, err := os.Stat(vars[name])
+		is.NoErr(err)
+		is.Equal(info.Mode().Perm(), os.FileMode(0o600)) // Files should only be readable by the owner.
+	}
+
+	// ssh-keygen should read the private key back and derive the public key that was signed.
+	pub, err := exec.Command("ssh-keygen", "-y", "-f", vars["FLEET_SSH_KEY_FILE"]).Output()
+	is.NoErr(err)
+	is.Equal(strings.Fields(string(pub))[1], strings.Fields(requests[0].PublicKey)[1])
+
+	knownHosts, err := os.ReadFile(vars["FLEET_SSH_KNOWN_HOSTS_FILE"])
+	is.NoErr(err)
+	is.True(strings.HasPrefix(string(knownHosts), "@cert-authority *.fleet.internal ssh-ed25519 "))
+
+	if _, err := exec.LookPath("ssh"); err == nil {
+		config, err := exec.Command("ssh", "-G", "-F", vars["FLEET_SSH_CONFIG_FILE"], "web1.fleet.internal").Output()
+		is.NoErr(err)
+		is.True(strings.Contains(string(config), "certificatefile "+vars["FLEET_SSH_CERT_FILE"])) // Config should apply to matching hosts.
+	}
+}
+
+func TestParseRetrieveSSH(t *testing.T) {
+	pterm.DisableOutput()
+	cases := []struct {
+		name     string
+		retrieve string
+		wantErr  bool
+	}{
+		{name: "valid", retrieve: `[{"type": "ssh", "rootCAPath": "root", "principals": ["deploy"], "outputDir": "ssh"}]`},
+		{name: "missing principals", retrieve: `[{"type": "ssh", "rootCAPath": "root", "outputDir": "ssh"}]`, wantErr: true},
+		{name: "missing output dir", retrieve: `[{"type": "ssh", "rootCAPath": "root", "principals": ["deploy"], "outputVariable": "SSH"}]`, wantErr: true},
+		{name: "host ca without hosts", retrieve: `[{"type": "ssh", "rootCAPath": "root", "principals": ["deploy"], "outputDir": "ssh", "hostCAKey": "ssh-ed25519 AAAA"}]`, wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			_, err := dga.ParseRetrieve(tc.retrieve)
+			is.Equal(err != nil, tc.wantErr)
+		})
+	}
+}
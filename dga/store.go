@@ -0,0 +1,245 @@
+package dga
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/DelineaXPM/dsv-gitlab/dsv"
+	yaml "gopkg.in/yaml.v3"
+)
+
+const (
+	// StoreModeUpsert creates the secret or updates it when it exists.
+	StoreModeUpsert = "upsert"
+	// StoreModeCreate only creates the secret and fails when it exists.
+	StoreModeCreate = "create"
+)
+
+// StoreSpec describes a secret to write to DSV. It is read from DSV_STORE as JSON or YAML,
+// and fields set on the command line override it.
+//
+//nolint:tagliatelle // Here 'camel' casing is used to match DSV_RETRIEVE.
+type StoreSpec struct {
+	Path        string                 `yaml:"path"`
+	Mode        string                 `yaml:"mode"`  // Mode is upsert (default) or create.
+	Merge       bool                   `yaml:"merge"` // Merge keeps existing data keys that are not being written.
+	Description string                 `yaml:"description"`
+	Attributes  map[string]interface{} `yaml:"attributes"`
+	Data        map[string]interface{} `yaml:"data"`     // Data is written as is.
+	DataFile    string                 `yaml:"dataFile"` // DataFile is a JSON or YAML document merged over Data.
+	FromEnv     map[string]string      `yaml:"fromEnv"`  // FromEnv maps data keys to the environment variable holding the value.
+	FromFile    map[string]string      `yaml:"fromFile"` // FromFile maps data keys to the file holding the value.
+}
+
+// ParseStoreSpec parses a JSON or YAML store spec.
+func ParseStoreSpec(spec string) (StoreSpec, error) {
+	s := StoreSpec{}
+	if err := yaml.Unmarshal([]byte(spec), &s); err != nil {
+		return StoreSpec{}, fmt.Errorf("unable to unmarshal store spec: %w", err)
+	}
+	return s, nil
+}
+
+// override replaces the fields of s with the non-zero fields of o.
+func (s *StoreSpec) override(o StoreSpec) {
+	if o.Path != "" {
+		s.Path = o.Path
+	}
+	if o.Mode != "" {
+		s.Mode = o.Mode
+	}
+	if o.Merge {
+		s.Merge = true
+	}
+	if o.Description != "" {
+		s.Description = o.Description
+	}
+	if o.DataFile != "" {
+		s.DataFile = o.DataFile
+	}
+	for k, v := range o.Attributes {
+		if s.Attributes == nil {
+			s.Attributes = map[string]interface{}{}
+		}
+		s.Attributes[k] = v
+	}
+	for k, v := range o.Data {
+		if s.Data == nil {
+			s.Data = map[string]interface{}{}
+		}
+		s.Data[k] = v
+	}
+	for k, v := range o.FromEnv {
+		if s.FromEnv == nil {
+			s.FromEnv = map[string]string{}
+		}
+		s.FromEnv[k] = v
+	}
+	for k, v := range o.FromFile {
+		if s.FromFile == nil {
+			s.FromFile = map[string]string{}
+		}
+		s.FromFile[k] = v
+	}
+}
+
+// validate checks the spec and applies defaults.
+func (s *StoreSpec) validate() error {
+	if s.Path == "" {
+		return errors.New("store path is required")
+	}
+	switch s.Mode {
+	case "":
+		s.Mode = StoreModeUpsert
+	case StoreModeUpsert, StoreModeCreate:
+	default:
+		return fmt.Errorf("unsupported store mode %q, expected %s or %s", s.Mode, StoreModeUpsert, StoreModeCreate)
+	}
+	if s.Merge && s.Mode == StoreModeCreate {
+		return errors.New("merge can only be used with upsert mode")
+	}
+	return nil
+}
+
+// data assembles the secret data from Data, DataFile, FromEnv and FromFile, later sources winning.
+// FromEnv is looked up in environment and relative files are resolved as the other local files of opts.
+func (s *StoreSpec) data(environment map[string]string, opts *Options) (map[string]interface{}, error) {
+	data := make(map[string]interface{}, len(s.Data))
+	for k, v := range s.Data {
+		data[k] = v
+	}
+	if s.DataFile != "" {
+		b, err := os.ReadFile(opts.rootPath(s.DataFile))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read data file: %w", err)
+		}
+		fromFile := map[string]interface{}{}
+		if err := yaml.Unmarshal(b, &fromFile); err != nil {
+			// Never include the content, it holds secret values.
+			return nil, fmt.Errorf("data file %s is not a JSON or YAML object", s.DataFile)
+		}
+		for k, v := range fromFile {
+			data[k] = v
+		}
+	}
+	for key, name := range s.FromEnv {
+		val, ok := environment[name]
+		if !ok {
+			return nil, fmt.Errorf("environment variable %s for data key %q is not set", name, key)
+		}
+		data[key] = val
+	}
+	for key, path := range s.FromFile {
+		b, err := os.ReadFile(opts.rootPath(path))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read file for data key %q: %w", key, err)
+		}
+		data[key] = strings.TrimSuffix(string(b), "\n")
+	}
+	if len(data) == 0 {
+		return nil, errors.New("no data to store, set data, dataFile, fromEnv or fromFile")
+	}
+	return data, nil
+}
+
+// Store writes a secret to DSV as described by DSV_STORE and the overrides from the command line.
+func Store(ctx context.Context, opts Options, overrides StoreSpec) error {
+	cfg, err := parseConfig(opts)
+	if err != nil {
+		return configError(err)
+	}
+	cfg.logDebug()
+
+	ctx, cancel := cfg.withTimeout(ctx)
+	defer cancel()
+
+	spec := StoreSpec{}
+	if cfg.StoreEnv != "" {
+		if spec, err = ParseStoreSpec(cfg.StoreEnv); err != nil {
+			return configError(err)
+		}
+	}
+	spec.override(overrides)
+	if err := spec.validate(); err != nil {
+		return configError(err)
+	}
+	data, err := spec.data(cfg.environment, &opts)
+	if err != nil {
+		return configError(err)
+	}
+
+	client, err := cfg.connect(ctx)
+	if err != nil {
+		return err
+	}
+	previous, stored, err := writeSecret(ctx, cfg.log(), client, &spec, data)
+	if err != nil {
+		cfg.log().Error("unable to store secret", logKeyPhase, phaseStore, logKeyPath, spec.Path, logKeyStatus, statusFailure, logKeyError, err)
+		return requestError(err, "unable to store secret")
+	}
+	if previous == nil {
+		cfg.log().Info("created secret", logKeyPhase, phaseStore, logKeyPath, spec.Path, "version", stored.Version, logKeyStatus, statusSuccess)
+	} else {
+		cfg.log().Info("updated secret", logKeyPhase, phaseStore, logKeyPath, spec.Path, "previousVersion", previous.Version, "version", stored.Version, logKeyStatus, statusSuccess)
+	}
+	return nil
+}
+
+// writeSecret creates or updates the secret at spec.Path with data, returning the previous version
+// (nil when the secret was created) and the stored version.
+func writeSecret(ctx context.Context, log *slog.Logger, client *dsv.Client, spec *StoreSpec, data map[string]interface{}) (*dsv.Secret, *dsv.Secret, error) {
+	existing, err := client.GetSecret(ctx, spec.Path)
+	if err != nil && !isNotFound(err) {
+		return nil, nil, err
+	}
+	in := &dsv.SecretInput{Description: spec.Description, Attributes: spec.Attributes, Data: data}
+
+	if existing == nil {
+		log.Debug("secret does not exist, creating", logKeyPhase, phaseStore, logKeyPath, spec.Path)
+		stored, err := client.CreateSecret(ctx, spec.Path, in)
+		return nil, stored, err
+	}
+	if spec.Mode == StoreModeCreate {
+		return nil, nil, fmt.Errorf("secret already exists and mode is %s", StoreModeCreate)
+	}
+
+	// An update replaces the whole secret, so keep what is not being changed.
+	if in.Description == "" {
+		in.Description = existing.Description
+	}
+	if spec.Merge {
+		in.Data = mergeMaps(existing.Data, data)
+		in.Attributes = mergeMaps(existing.Attributes, spec.Attributes)
+	} else if in.Attributes == nil {
+		in.Attributes = existing.Attributes
+	}
+	log.Debug("secret exists, updating", logKeyPhase, phaseStore, logKeyPath, spec.Path, "version", existing.Version)
+	stored, err := client.UpdateSecret(ctx, spec.Path, in)
+	return existing, stored, err
+}
+
+// mergeMaps returns a new map with the keys of base overridden by over.
+func mergeMaps(base, over map[string]interface{}) map[string]interface{} {
+	if len(base)+len(over) == 0 {
+		return nil
+	}
+	merged := make(map[string]interface{}, len(base)+len(over))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range over {
+		merged[k] = v
+	}
+	return merged
+}
+
+// isNotFound reports whether err is a DSV 404 response.
+func isNotFound(err error) bool {
+	var apiErr *dsv.APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
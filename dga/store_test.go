@@ -0,0 +1,130 @@
+package dga_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/pterm/pterm"
+
+	dga "github.com/DelineaXPM/dsv-gitlab/dga"
+	"github.com/DelineaXPM/dsv-gitlab/dsv"
+	"github.com/DelineaXPM/dsv-gitlab/dsv/dsvtest"
+)
+
+func TestStore(t *testing.T) {
+	pterm.DisableOutput()
+	dataFile := filepath.Join(t.TempDir(), "data.yaml")
+	if err := os.WriteFile(dataFile, []byte("host: db.internal\nport: 5432\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(passwordFile, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name      string
+		existing  *dsv.SecretInput
+		storeEnv  string
+		overrides dga.StoreSpec
+		want      *dsv.Secret
+		wantErr   bool
+	}{
+		{
+			name:     "create from spec",
+			storeEnv: `{"path": "ci:db", "description": "db", "attributes": {"owner": "ci"}, "data": {"user": "app"}, "fromEnv": {"password": "TEST_DB_PASSWORD"}}`,
+			want: &dsv.Secret{
+				Path: "ci:db", Version: "1", Description: "db",
+				Attributes: map[string]interface{}{"owner": "ci"},
+				Data:       map[string]interface{}{"user": "app", "password": "from-env"},
+			},
+		},
+		{
+			name:      "create from command line with data file",
+			overrides: dga.StoreSpec{Path: "ci:db", DataFile: dataFile, FromFile: map[string]string{"password": passwordFile}},
+			want: &dsv.Secret{
+				Path: "ci:db", Version: "1",
+				Data: map[string]interface{}{"host": "db.internal", "port": float64(5432), "password": "from-file"},
+			},
+		},
+		{
+			name:     "upsert replaces data and keeps description",
+			existing: &dsv.SecretInput{Description: "db", Data: map[string]interface{}{"user": "app", "password": "old"}},
+			storeEnv: "path: ci:db\ndata:\n  password: new\n",
+			want: &dsv.Secret{
+				Path: "ci:db", Version: "2", Description: "db",
+				Data: map[string]interface{}{"password": "new"},
+			},
+		},
+		{
+			name:      "upsert merge keeps existing keys",
+			existing:  &dsv.SecretInput{Data: map[string]interface{}{"user": "app", "password": "old"}},
+			storeEnv:  "path: ci:db\ndata:\n  password: new\n",
+			overrides: dga.StoreSpec{Merge: true},
+			want: &dsv.Secret{
+				Path: "ci:db", Version: "2",
+				Data: map[string]interface{}{"user": "app", "password": "new"},
+			},
+		},
+		{
+			name:     "create mode fails when secret exists",
+			existing: &dsv.SecretInput{Data: map[string]interface{}{"password": "old"}},
+			storeEnv: `{"path": "ci:db", "mode": "create", "data": {"password": "new"}}`,
+			wantErr:  true,
+		},
+		{name: "missing path", storeEnv: `{"data": {"password": "new"}}`, wantErr: true},
+		{name: "missing data", storeEnv: `{"path": "ci:db"}`, wantErr: true},
+		{name: "unset env var", storeEnv: `{"path": "ci:db", "fromEnv": {"password": "TEST_DB_MISSING"}}`, wantErr: true},
+		{name: "invalid mode", storeEnv: `{"path": "ci:db", "mode": "replace", "data": {"a": "b"}}`, wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			server := dsvtest.NewServer()
+			defer server.Close()
+			if tc.existing != nil {
+				_, err := server.Client().CreateSecret(context.Background(), "ci:db", tc.existing)
+				is.NoErr(err)
+			}
+			opts := hermeticRun(t, server, map[string]string{"DSV_STORE": tc.storeEnv, "TEST_DB_PASSWORD": "from-env"})
+
+			err := dga.Store(context.Background(), opts, tc.overrides)
+			if tc.wantErr {
+				is.True(err != nil) // Should fail.
+				return
+			}
+			is.NoErr(err)
+			got := server.Secret("ci:db")
+			is.Equal(&dsv.Secret{Path: got.Path, Version: got.Version, Description: got.Description, Attributes: got.Attributes, Data: got.Data}, tc.want) // Stored secret should match.
+		})
+	}
+}
+
+func TestStoreInjectedSources(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+	server := dsvtest.NewServer()
+	defer server.Close()
+
+	opts := hermeticRun(t, server, map[string]string{
+		"DSV_STORE":     `{"path": "ci:db", "dataFile": "data.yaml", "fromEnv": {"user": "DB_USER", "password": "DB_PASSWORD"}, "fromFile": {"cert": "certs/db.pem"}}`,
+		"DB_USER":       "app",
+		"DSV_CLIENT_ID": "", // Local mode, the client ID comes from the .env file.
+	})
+	opts.Local = true
+	is.NoErr(os.WriteFile(filepath.Join(opts.Root, ".env"), []byte("DSV_CLIENT_ID="+dsvtest.ClientID+"\nDB_PASSWORD=from-dotenv\n"), 0o600))
+	is.NoErr(os.WriteFile(filepath.Join(opts.Root, "data.yaml"), []byte("host: db.internal\n"), 0o600))
+	is.NoErr(os.MkdirAll(filepath.Join(opts.Root, "certs"), 0o700))
+	is.NoErr(os.WriteFile(filepath.Join(opts.Root, "certs", "db.pem"), []byte("PEM\n"), 0o600))
+
+	is.NoErr(dga.Store(context.Background(), opts, dga.StoreSpec{}))
+	is.Equal(server.Secret("ci:db").Data, map[string]interface{}{
+		"host":     "db.internal",
+		"user":     "app",         // Values should come from Options.Environment,
+		"password": "from-dotenv", // and from the local .env file.
+		"cert":     "PEM",         // Relative files should be resolved against Root.
+	})
+}
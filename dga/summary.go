@@ -0,0 +1,129 @@
+package dga
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pterm/pterm"
+)
+
+// Status of an entry in the run summary.
+const (
+	resultOK      = "ok"
+	resultFailed  = "failed"
+	resultSkipped = "skipped" // resultSkipped entries were not reached because the run stopped earlier.
+)
+
+// entryResult is the outcome of one DSV_RETRIEVE entry.
+type entryResult struct {
+	Type     string
+	Path     string // Path is the DSV path read, the PKI root for pki and ssh entries.
+	Key      string // Key is the data key, registry, kube context, common name or principals the entry is about.
+	Variable string // Variable is the exported variable, if any.
+	Status   string
+	Duration time.Duration
+	Err      error // Err is why the entry failed.
+}
+
+// newEntryResults returns the results for items, all skipped until they are done.
+func newEntryResults(items []SecretToRetrieve) []entryResult {
+	results := make([]entryResult, 0, len(items))
+	for i := range items {
+		item := &items[i]
+		r := entryResult{Type: item.entryType(), Path: item.path(), Variable: strings.ToUpper(item.OutputVariable), Status: resultSkipped}
+		switch item.Type {
+		case EntryTypePKI:
+			r.Key = item.CommonName
+		case EntryTypeSSH:
+			r.Key = strings.Join(item.Principals, ",")
+		case EntryTypeDocker:
+			r.Key = item.Registry
+		case EntryTypeKube:
+			r.Key = item.Context
+		case EntryTypeAWS, EntryTypeAzure, EntryTypeGCP:
+			r.Key = item.Profile
+		default:
+			r.Key = item.SecretKey
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+// done records the outcome of the entry that started at start.
+func (r *entryResult) done(log *slog.Logger, start time.Time, err error) {
+	r.Duration = time.Since(start)
+	r.Status, r.Err = resultOK, err
+	args := []any{logKeyPhase, phaseRetrieve, logKeyPath, r.Path, "type", r.Type, logKeyDuration, r.Duration}
+	if err != nil {
+		r.Status = resultFailed
+		log.Debug("entry failed", append(args, logKeyStatus, statusFailure, logKeyError, err)...)
+		return
+	}
+	log.Debug("entry done", append(args, logKeyStatus, statusSuccess)...)
+}
+
+// logSummary reports the results at the end of a run: as a table for the pretty and plain
+// formats, and as one result event per entry followed by a summary event otherwise.
+func logSummary(log *slog.Logger, results []entryResult, total time.Duration) {
+	failed := 0
+	for _, r := range results {
+		if r.Status == resultFailed {
+			failed++
+		}
+	}
+	h, ok := log.Handler().(*humanHandler)
+	if !ok {
+		for _, r := range results {
+			args := []any{logKeyPath, r.Path, "type", r.Type, "key", r.Key, "variable", r.Variable, logKeyStatus, r.Status, logKeyDuration, r.Duration}
+			if r.Err != nil {
+				args = append(args, logKeyError, r.Err)
+			}
+			log.Info("result", args...)
+		}
+		log.Info("summary", "entries", len(results), "failed", failed, logKeyDuration, total)
+		return
+	}
+	if len(results) == 0 || !h.Enabled(context.Background(), slog.LevelInfo) {
+		return
+	}
+
+	rows := [][]string{{"PATH", "KEY", "VARIABLE", "STATUS", "DURATION"}}
+	for _, r := range results {
+		duration := "-"
+		if r.Status != resultSkipped {
+			duration = formatDuration(r.Duration)
+		}
+		rows = append(rows, []string{r.Path, orDash(r.Key), orDash(r.Variable), r.Status, duration})
+	}
+	if h.w == nil {
+		_ = pterm.DefaultTable.WithHasHeader().WithData(rows).Render()
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	tw := tabwriter.NewWriter(h.w, 0, 0, 2, ' ', 0)
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	_ = tw.Flush()
+}
+
+// formatDuration rounds d for the summary table.
+func formatDuration(d time.Duration) string {
+	if d < time.Second {
+		return fmt.Sprintf("%dms", d.Milliseconds())
+	}
+	return d.Round(10 * time.Millisecond).String()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
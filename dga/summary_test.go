@@ -0,0 +1,60 @@
+package dga_test
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/pterm/pterm"
+
+	dga "github.com/DelineaXPM/dsv-gitlab/dga"
+	"github.com/DelineaXPM/dsv-gitlab/dsv/dsvtest"
+)
+
+func TestRunSummary(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.SetSecret("ci:db", map[string]interface{}{"user": "app", "password": "s3cret"})
+
+	opts := hermeticRun(t, server, map[string]string{"DSV_LOG_FORMAT": "plain", "DSV_RETRIEVE": `[
+		{"secretPath": "ci:db", "secretKey": "user", "outputVariable": "db_user"},
+		{"secretPath": "ci:missing", "secretKey": "password", "outputVariable": "MISSING"},
+		{"type": "docker", "secretPath": "ci:registry", "registry": "registry.example.com"}
+	]`})
+	got := captureStderr(t, func() { is.True(dga.Run(context.Background(), opts) != nil) })
+
+	// Durations vary, everything else is fixed.
+	got = regexp.MustCompile(`\d+ms`).ReplaceAllString(got, "Nms")
+	start, end := strings.Index(got, "section_start:"), strings.Index(got, "section_end:")
+	is.True(start >= 0 && end > start) // The detail should be in a collapsible section.
+	is.True(strings.Contains(got[start:], "[collapsed=true]\r\x1b[0KRetrieving 3 entries from DSV\n"))
+	is.True(strings.Contains(got[start:end], `ERROR   "ci:missing": failed to fetch secret`)) // Errors should be in the section.
+	is.True(strings.HasSuffix(got, strings.Join([]string{
+		"PATH         KEY                   VARIABLE  STATUS   DURATION",
+		"ci:db        user                  DB_USER   ok       Nms",
+		"ci:missing   password              MISSING   failed   Nms",
+		"ci:registry  registry.example.com  -         skipped  -",
+		"",
+	}, "\n"))) // The summary table should end the output.
+}
+
+func TestRunSummaryLocal(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.SetSecret("ci:db", map[string]interface{}{"password": "s3cret"})
+
+	opts := hermeticRun(t, server, map[string]string{"DSV_LOG_FORMAT": "plain", "DSV_RETRIEVE": `[
+		{"secretPath": "ci:db", "secretKey": "password", "outputVariable": "DB_PASSWORD"}
+	]`})
+	opts.Local = true
+	opts.OutFile = "local.env"
+	got := captureStderr(t, func() { is.NoErr(dga.Run(context.Background(), opts)) })
+	is.True(!strings.Contains(got, "section_")) // Sections are only written in GitLab.
+	is.True(strings.Contains(got, "ci:db  password  DB_PASSWORD  ok"))
+}
@@ -0,0 +1,149 @@
+package dga
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/DelineaXPM/dsv-gitlab/dsv"
+)
+
+// PermissionReadWriteExecuteOwner is the octal permission for a directory only the owner can use.
+const PermissionReadWriteExecuteOwner = 0o700
+
+// tokenCacheKeyContext separates the cache key derivation from any other use of the client secret.
+const tokenCacheKeyContext = "dsv-gitlab token cache v1"
+
+// fileTokenCache is a dsv.TokenCache stored in a file encrypted with AES-GCM.
+// The key is derived from the client credentials and pipeline ID, so only jobs of the
+// same pipeline using the same credentials can read it.
+type fileTokenCache struct {
+	path string
+	key  [sha256.Size]byte
+}
+
+// newTokenCache returns the token cache for this pipeline, or nil when caching is disabled or not possible.
+func (cfg *Config) newTokenCache() dsv.TokenCache {
+	if !cfg.TokenCache {
+		return nil
+	}
+	if cfg.CIPipelineID == "" {
+		cfg.log().Warn("DSV_TOKEN_CACHE is set but CI_PIPELINE_ID is empty, token caching is disabled", logKeyPhase, phaseAuth)
+		return nil
+	}
+	path := filepath.Join(cfg.outputPath(cfg.TokenCacheDir), tokenCacheFileName(cfg.CIPipelineID, cfg.ClientIDEnv))
+	cfg.log().Debug("token cache file", logKeyPhase, phaseAuth, "file", path)
+	return newFileTokenCache(path, tokenCacheKeyContext, cfg.ClientIDEnv, cfg.ClientSecretEnv, cfg.CIPipelineID)
+}
+
+// tokenCacheFileName names the cache file after the pipeline and a short hash of the client ID, so jobs
+// of one pipeline using different credentials keep separate caches instead of replacing each other's.
+// The client secret is left out of the name, it only goes into the key.
+func tokenCacheFileName(pipelineID, clientID string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d:%s%d:%s", len(tokenCacheKeyContext), tokenCacheKeyContext, len(clientID), clientID)
+	return fmt.Sprintf("token-%s-%x.bin", pipelineID, h.Sum(nil)[:4])
+}
+
+// newFileTokenCache returns a cache stored at path, with a key derived from keyParts.
+func newFileTokenCache(path string, keyParts ...string) *fileTokenCache {
+	h := sha256.New()
+	for _, part := range keyParts {
+		// Length prefix each part so different splits of the same bytes give different keys.
+		fmt.Fprintf(h, "%d:%s", len(part), part)
+	}
+	c := &fileTokenCache{path: path}
+	copy(c.key[:], h.Sum(nil))
+	return c
+}
+
+// Load decrypts the cached token, returning nil when there is no cache file.
+func (c *fileTokenCache) Load() (*dsv.Token, error) {
+	b, err := os.ReadFile(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil //nolint:nilnil // an empty cache is not an error.
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read token cache: %w", err)
+	}
+	aead, err := c.aead()
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < aead.NonceSize() {
+		return nil, errors.New("token cache is truncated")
+	}
+	plain, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt token cache: %w", err)
+	}
+	token := &dsv.Token{}
+	if err := json.Unmarshal(plain, token); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal token cache: %w", err)
+	}
+	return token, nil
+}
+
+// Store encrypts and writes the token, replacing the file atomically so concurrent jobs never read a partial file.
+func (c *fileTokenCache) Store(token *dsv.Token) error {
+	plain, err := json.Marshal(token)
+	if err != nil {
+		return fmt.Errorf("unable to marshal token: %w", err)
+	}
+	aead, err := c.aead()
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return fmt.Errorf("unable to generate nonce: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, plain, nil)
+
+	if err := os.MkdirAll(filepath.Dir(c.path), PermissionReadWriteExecuteOwner); err != nil {
+		return fmt.Errorf("unable to create token cache directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		return fmt.Errorf("unable to create token cache: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(sealed); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to write token cache: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("unable to write token cache: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		return fmt.Errorf("unable to replace token cache: %w", err)
+	}
+	return nil
+}
+
+// Clear removes the cache file.
+func (c *fileTokenCache) Clear() error {
+	if err := os.Remove(c.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("unable to remove token cache: %w", err)
+	}
+	return nil
+}
+
+func (c *fileTokenCache) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(c.key[:])
+	if err != nil {
+		return nil, fmt.Errorf("unable to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create cipher: %w", err)
+	}
+	return aead, nil
+}
@@ -0,0 +1,165 @@
+package dga_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	dga "github.com/DelineaXPM/dsv-gitlab/dga"
+	"github.com/DelineaXPM/dsv-gitlab/dsv"
+	"github.com/DelineaXPM/dsv-gitlab/dsv/dsvtest"
+)
+
+func TestFileTokenCacheRoundTrip(t *testing.T) {
+	is := is.New(t)
+	path := filepath.Join(t.TempDir(), "cache", "token.bin")
+	cache := dga.NewFileTokenCache(path, "client", "secret", "1001")
+
+	token, err := cache.Load()
+	is.NoErr(err)
+	is.True(token == nil) // A missing cache file should be empty, not an error.
+
+	expires := time.Now().Add(time.Hour).Round(0)
+	is.NoErr(cache.Store(&dsv.Token{AccessToken: "access-1", RefreshToken: "refresh-1", ExpiresAt: expires}))
+	b, err := os.ReadFile(path)
+	is.NoErr(err)
+	is.True(!containsAny(string(b), "access-1", "refresh-1")) // The file should be encrypted.
+	info, err := os.Stat(path)
+	is.NoErr(err)
+	is.Equal(info.Mode().Perm(), os.FileMode(0o600))
+
+	token, err = cache.Load()
+	is.NoErr(err)
+	is.Equal(token.AccessToken, "access-1")
+	is.Equal(token.RefreshToken, "refresh-1")
+	is.True(token.ExpiresAt.Equal(expires))
+
+	is.NoErr(cache.Clear())
+	is.NoErr(cache.Clear()) // Clearing an empty cache should succeed.
+	token, err = cache.Load()
+	is.NoErr(err)
+	is.True(token == nil)
+}
+
+func TestFileTokenCacheReplace(t *testing.T) {
+	is := is.New(t)
+	dir := t.TempDir()
+	cache := dga.NewFileTokenCache(filepath.Join(dir, "token.bin"), "client", "secret", "1001")
+
+	is.NoErr(cache.Store(&dsv.Token{AccessToken: "access-1"}))
+	is.NoErr(cache.Store(&dsv.Token{AccessToken: "access-2"}))
+	token, err := cache.Load()
+	is.NoErr(err)
+	is.Equal(token.AccessToken, "access-2") // The newest token should replace the old one.
+
+	entries, err := os.ReadDir(dir)
+	is.NoErr(err)
+	is.Equal(len(entries), 1) // No temporary files should be left behind.
+	is.Equal(entries[0].Name(), "token.bin")
+}
+
+func TestFileTokenCacheCorrupt(t *testing.T) {
+	cases := []struct {
+		name    string
+		corrupt func(t *testing.T, path string)
+	}{
+		{name: "wrong key", corrupt: func(t *testing.T, path string) {
+			t.Helper()
+			if err := dga.NewFileTokenCache(path, "client", "other-secret", "1001").Store(&dsv.Token{AccessToken: "other"}); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{name: "other pipeline", corrupt: func(t *testing.T, path string) {
+			t.Helper()
+			if err := dga.NewFileTokenCache(path, "client", "secret", "1002").Store(&dsv.Token{AccessToken: "other"}); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{name: "tampered ciphertext", corrupt: func(t *testing.T, path string) {
+			t.Helper()
+			rewrite(t, path, func(b []byte) []byte { b[len(b)-1] ^= 0xff; return b })
+		}},
+		{name: "truncated ciphertext", corrupt: func(t *testing.T, path string) {
+			t.Helper()
+			rewrite(t, path, func(b []byte) []byte { return b[:len(b)-4] })
+		}},
+		{name: "truncated nonce", corrupt: func(t *testing.T, path string) {
+			t.Helper()
+			rewrite(t, path, func(b []byte) []byte { return b[:4] })
+		}},
+		{name: "empty file", corrupt: func(t *testing.T, path string) {
+			t.Helper()
+			rewrite(t, path, func([]byte) []byte { return nil })
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			path := filepath.Join(t.TempDir(), "token.bin")
+			cache := dga.NewFileTokenCache(path, "client", "secret", "1001")
+			is.NoErr(cache.Store(&dsv.Token{AccessToken: "cached", ExpiresAt: time.Now().Add(time.Hour)}))
+			tc.corrupt(t, path)
+
+			token, err := cache.Load()
+			is.True(err != nil) // A corrupt cache should not load.
+			is.True(token == nil)
+
+			server := dsvtest.NewServer()
+			defer server.Close()
+			client := server.Client()
+			auth := dsv.NewClientCredentials(client, dsvtest.ClientID, dsvtest.ClientSecret)
+			auth.Cache = cache
+			access, err := auth.AccessToken(context.Background())
+			is.NoErr(err) // A corrupt cache should be a miss, not a failure.
+			is.Equal(access, "access-1")
+
+			token, err = cache.Load()
+			is.NoErr(err)
+			is.Equal(token.AccessToken, "access-1") // The new token should replace the corrupt cache.
+		})
+	}
+}
+
+func TestFileTokenCacheExpired(t *testing.T) {
+	is := is.New(t)
+	server := dsvtest.NewServer()
+	defer server.Close()
+	cache := dga.NewFileTokenCache(filepath.Join(t.TempDir(), "token.bin"), "client", "secret", "1001")
+	is.NoErr(cache.Store(&dsv.Token{AccessToken: "expired", ExpiresAt: time.Now().Add(-time.Minute)}))
+
+	auth := dsv.NewClientCredentials(server.Client(), dsvtest.ClientID, dsvtest.ClientSecret)
+	auth.Cache = cache
+	access, err := auth.AccessToken(context.Background())
+	is.NoErr(err)
+	is.Equal(access, "access-1") // An expired cached token should not be used.
+	token, err := cache.Load()
+	is.NoErr(err)
+	is.Equal(token.AccessToken, "access-1")
+}
+
+// rewrite replaces the contents of path with fn applied to them.
+func rewrite(t *testing.T, path string, fn func([]byte) []byte) {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, fn(b), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// containsAny reports whether s contains any of substrs.
+func containsAny(s string, substrs ...string) bool {
+	for _, sub := range substrs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
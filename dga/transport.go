@@ -0,0 +1,175 @@
+package dga
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// spkiPinPrefix is the optional prefix of a pinned public key hash, matching curl's --pinnedpubkey format.
+const spkiPinPrefix = "sha256//"
+
+// errPinMismatch is the TLS handshake failure when no certificate of DSV matches DSV_TLS_PINNED_SPKI.
+var errPinMismatch = errors.New("no certificate in the chain matches DSV_TLS_PINNED_SPKI")
+
+// tlsVersions maps the accepted DSV_TLS_MIN_VERSION values to crypto/tls versions.
+//
+//nolint:gochecknoglobals // lookup table.
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// NewHTTPClient builds the HTTP client used to talk to DSV, applying the CA bundle, proxy and TLS settings from cfg.
+func NewHTTPClient(cfg *Config) (*http.Client, error) {
+	cfg.log().Debug("NewHTTPClient()")
+	tlsConfig, err := cfg.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := cfg.proxyFunc()
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone() //nolint:forcetypeassert // DefaultTransport is always *http.Transport.
+	transport.TLSClientConfig = tlsConfig
+	transport.Proxy = proxy
+	cfg.log().Debug("NewHTTPClient() success", logKeyStatus, statusSuccess)
+	return &http.Client{Timeout: defaultTimeout, Transport: transport}, nil
+}
+
+// tlsConfig builds the client TLS configuration from the DSV_CA_CERT*, DSV_TLS_MIN_VERSION and DSV_TLS_PINNED_SPKI settings.
+func (cfg *Config) tlsConfig() (*tls.Config, error) {
+	minVersion, ok := tlsVersions[cfg.TLSMinVersion]
+	if !ok {
+		return nil, fmt.Errorf("unsupported DSV_TLS_MIN_VERSION %q, expected 1.2 or 1.3", cfg.TLSMinVersion)
+	}
+	tlsConfig := &tls.Config{MinVersion: minVersion} //nolint:gosec // MinVersion is validated above.
+
+	if cfg.CACert != "" || cfg.CACertFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			cfg.log().Debug("unable to load system cert pool, using only the provided CA", logKeyPhase, phaseConfig, logKeyError, err)
+			pool = x509.NewCertPool()
+		}
+		if cfg.CACert != "" && !pool.AppendCertsFromPEM([]byte(cfg.CACert)) {
+			return nil, errors.New("DSV_CA_CERT does not contain any PEM encoded certificates")
+		}
+		if cfg.CACertFile != "" {
+			b, err := os.ReadFile(cfg.CACertFile)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read DSV_CA_CERT_FILE: %w", err)
+			}
+			if !pool.AppendCertsFromPEM(b) {
+				return nil, fmt.Errorf("DSV_CA_CERT_FILE %s does not contain any PEM encoded certificates", cfg.CACertFile)
+			}
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if len(cfg.PinnedSPKI) > 0 {
+		pins := make(map[string]bool, len(cfg.PinnedSPKI))
+		for _, pin := range cfg.PinnedSPKI {
+			pin = strings.TrimPrefix(strings.TrimSpace(pin), spkiPinPrefix)
+			if b, err := base64.StdEncoding.DecodeString(pin); err != nil || len(b) != sha256.Size {
+				return nil, fmt.Errorf("invalid DSV_TLS_PINNED_SPKI entry %q, expected base64 encoded sha256 hash", pin)
+			}
+			pins[pin] = true
+		}
+		// Runs after normal chain verification, so pinning only narrows what is trusted.
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			for _, cert := range cs.PeerCertificates {
+				sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				if pins[base64.StdEncoding.EncodeToString(sum[:])] {
+					return nil
+				}
+			}
+			return errPinMismatch
+		}
+	}
+	return tlsConfig, nil
+}
+
+// proxyFunc returns the proxy selector for requests based on HTTPS_PROXY and NO_PROXY.
+// Only the configured values are used, so the result does not depend on the process environment.
+func (cfg *Config) proxyFunc() (func(*http.Request) (*url.URL, error), error) {
+	if cfg.HTTPSProxy == "" {
+		return nil, nil //nolint:nilnil // nil proxy func means direct connections.
+	}
+	proxyURL, err := url.Parse(cfg.HTTPSProxy)
+	if err != nil || proxyURL.Host == "" {
+		// Allow the common "host:port" form without a scheme.
+		proxyURL, err = url.Parse("http://" + cfg.HTTPSProxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid HTTPS_PROXY: %w", err)
+		}
+	}
+	noProxy := parseNoProxy(cfg.NoProxy)
+	return func(req *http.Request) (*url.URL, error) {
+		if noProxy.matches(req.URL) {
+			return nil, nil
+		}
+		return proxyURL, nil
+	}, nil
+}
+
+// noProxyRules is a parsed NO_PROXY value.
+type noProxyRules struct {
+	all     bool
+	cidrs   []*net.IPNet
+	domains []string
+}
+
+// parseNoProxy parses a comma separated NO_PROXY list of hosts, domain suffixes, IPs and CIDR ranges, or "*".
+func parseNoProxy(noProxy string) noProxyRules {
+	rules := noProxyRules{}
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+			continue
+		case entry == "*":
+			rules.all = true
+		default:
+			if _, cidr, err := net.ParseCIDR(entry); err == nil {
+				rules.cidrs = append(rules.cidrs, cidr)
+				continue
+			}
+			if host, _, err := net.SplitHostPort(entry); err == nil {
+				entry = host
+			}
+			rules.domains = append(rules.domains, strings.TrimPrefix(entry, "."))
+		}
+	}
+	return rules
+}
+
+// matches reports whether u should bypass the proxy.
+func (r noProxyRules) matches(u *url.URL) bool {
+	if r.all {
+		return true
+	}
+	host := strings.ToLower(u.Hostname())
+	if ip := net.ParseIP(host); ip != nil {
+		for _, cidr := range r.cidrs {
+			if cidr.Contains(ip) {
+				return true
+			}
+		}
+	}
+	for _, domain := range r.domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
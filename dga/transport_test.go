@@ -0,0 +1,171 @@
+package dga_test
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/pterm/pterm"
+
+	dga "github.com/DelineaXPM/dsv-gitlab/dga"
+)
+
+func TestNewHTTPClientTLS(t *testing.T) {
+	pterm.DisableOutput()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	caPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, []byte(caPEM), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(server.Certificate().RawSubjectPublicKeyInfo)
+	pin := base64.StdEncoding.EncodeToString(sum[:])
+	otherPin := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+	cases := []struct {
+		name       string
+		cfg        dga.Config
+		wantConfig bool // wantConfig is false when NewHTTPClient should reject the settings.
+		wantErr    bool
+	}{
+		{
+			name:       "untrusted server certificate",
+			cfg:        dga.Config{TLSMinVersion: "1.2"},
+			wantConfig: true,
+			wantErr:    true,
+		},
+		{
+			name:       "ca from DSV_CA_CERT",
+			cfg:        dga.Config{TLSMinVersion: "1.2", CACert: caPEM},
+			wantConfig: true,
+		},
+		{
+			name:       "ca from DSV_CA_CERT_FILE",
+			cfg:        dga.Config{TLSMinVersion: "1.2", CACertFile: caFile},
+			wantConfig: true,
+		},
+		{
+			name:       "matching pin",
+			cfg:        dga.Config{TLSMinVersion: "1.2", CACert: caPEM, PinnedSPKI: []string{otherPin, "sha256//" + pin}},
+			wantConfig: true,
+		},
+		{
+			name:       "mismatched pin",
+			cfg:        dga.Config{TLSMinVersion: "1.2", CACert: caPEM, PinnedSPKI: []string{otherPin}},
+			wantConfig: true,
+			wantErr:    true,
+		},
+		{
+			name: "invalid pin",
+			cfg:  dga.Config{TLSMinVersion: "1.2", PinnedSPKI: []string{"not-a-hash"}},
+		},
+		{
+			name: "invalid ca",
+			cfg:  dga.Config{TLSMinVersion: "1.2", CACert: "not a pem"},
+		},
+		{
+			name: "missing ca file",
+			cfg:  dga.Config{TLSMinVersion: "1.2", CACertFile: filepath.Join(t.TempDir(), "missing.pem")},
+		},
+		{
+			name: "invalid min version",
+			cfg:  dga.Config{TLSMinVersion: "1.0"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			client, err := dga.NewHTTPClient(&tc.cfg)
+			if !tc.wantConfig {
+				is.True(err != nil) // Should reject the configuration.
+				return
+			}
+			is.NoErr(err)
+
+			resp, err := client.Get(server.URL)
+			if tc.wantErr {
+				is.True(err != nil) // Should fail the TLS handshake.
+				return
+			}
+			is.NoErr(err)
+			resp.Body.Close()
+			is.Equal(resp.StatusCode, http.StatusOK)
+		})
+	}
+}
+
+func TestNewHTTPClientMinVersion(t *testing.T) {
+	pterm.DisableOutput()
+	is := is.New(t)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12} //nolint:gosec // intentionally old server.
+	server.StartTLS()
+	defer server.Close()
+	caPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+
+	client, err := dga.NewHTTPClient(&dga.Config{TLSMinVersion: "1.2", CACert: caPEM})
+	is.NoErr(err)
+	resp, err := client.Get(server.URL)
+	is.NoErr(err) // TLS 1.2 server should be accepted by default.
+	resp.Body.Close()
+
+	client, err = dga.NewHTTPClient(&dga.Config{TLSMinVersion: "1.3", CACert: caPEM})
+	is.NoErr(err)
+	_, err = client.Get(server.URL) //nolint:bodyclose // no response on error.
+	is.True(err != nil)             // TLS 1.2 server should be rejected when 1.3 is required.
+}
+
+func TestNewHTTPClientProxy(t *testing.T) {
+	pterm.DisableOutput()
+	cfg := dga.Config{
+		TLSMinVersion: "1.2",
+		HTTPSProxy:    "proxy.internal:3128",
+		NoProxy:       "localhost, .corp.example.com,10.0.0.0/8,mock.local:8443",
+	}
+	client, err := dga.NewHTTPClient(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := client.Transport.(*http.Transport).Proxy //nolint:forcetypeassert // set by NewHTTPClient.
+
+	cases := []struct {
+		url       string
+		wantProxy bool
+	}{
+		{url: "https://tenant.secretsvaultcloud.com/v1/token", wantProxy: true},
+		{url: "https://localhost:8443/v1", wantProxy: false},
+		{url: "https://dsv.corp.example.com/v1", wantProxy: false},
+		{url: "https://corp.example.com/v1", wantProxy: false},
+		{url: "https://notcorp.example.com/v1", wantProxy: true},
+		{url: "https://10.1.2.3/v1", wantProxy: false},
+		{url: "https://11.1.2.3/v1", wantProxy: true},
+		{url: "https://mock.local/v1", wantProxy: false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.url, func(t *testing.T) {
+			is := is.New(t)
+			req, err := http.NewRequest(http.MethodGet, tc.url, nil)
+			is.NoErr(err)
+			got, err := proxy(req)
+			is.NoErr(err)
+			if !tc.wantProxy {
+				is.Equal(got, nil) // Should bypass the proxy.
+				return
+			}
+			is.True(got != nil) // Should use the proxy.
+			is.Equal(got.String(), "http://proxy.internal:3128")
+		})
+	}
+}
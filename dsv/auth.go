@@ -0,0 +1,150 @@
+package dsv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// tokenExpirySkew is how long before expiry a token is considered expired, so it is not used for a request that outlives it.
+// Tokens with a shorter lifetime use half of it instead, see expirySkew.
+const tokenExpirySkew = time.Minute
+
+// TokenCache stores tokens between runs.
+type TokenCache interface {
+	// Load returns the cached token, or nil when there is none.
+	Load() (*Token, error)
+	Store(token *Token) error
+	Clear() error
+}
+
+// ClientCredentials is an AuthProvider that requests an access token with a client ID and secret.
+// The token is reused until shortly before it expires, then renewed with its refresh token when
+// there is one. When Cache is set the token is also shared through it.
+type ClientCredentials struct {
+	Client       *Client // Client is used to call the token endpoint.
+	ClientID     string
+	ClientSecret string
+	Cache        TokenCache // Cache is optional.
+
+	mu     sync.Mutex
+	token  *Token
+	loaded bool // loaded is set once the cache has been read.
+}
+
+// NewClientCredentials returns a ClientCredentials provider that requests tokens through c.
+func NewClientCredentials(c *Client, clientID, clientSecret string) *ClientCredentials {
+	return &ClientCredentials{Client: c, ClientID: clientID, ClientSecret: clientSecret}
+}
+
+// AccessToken returns the current access token, renewing it first when it is missing or about to expire.
+func (p *ClientCredentials) AccessToken(ctx context.Context) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.loaded && p.Cache != nil {
+		p.loaded = true
+		cached, err := p.Cache.Load()
+		if err != nil {
+			p.Client.logger().DebugContext(ctx, "unable to load cached token", "error", err)
+		}
+		p.token = cached
+	}
+	if p.token != nil && !p.token.Expired(time.Now(), expirySkew(p.token)) {
+		return p.token.AccessToken, nil
+	}
+
+	token, err := p.renew(ctx)
+	if err != nil {
+		return "", err
+	}
+	p.token = token
+	if p.Cache != nil {
+		if err := p.Cache.Store(token); err != nil {
+			p.Client.logger().DebugContext(ctx, "unable to cache token", "error", err)
+		}
+	}
+	return token.AccessToken, nil
+}
+
+// expirySkew returns tokenExpirySkew, capped at half the lifetime of token so a short lived token
+// is not considered expired as soon as it is issued.
+func expirySkew(token *Token) time.Duration {
+	if lifetime := time.Duration(token.ExpiresIn) * time.Second; lifetime > 0 && lifetime/2 < tokenExpirySkew {
+		return lifetime / 2
+	}
+	return tokenExpirySkew
+}
+
+// Invalidate discards the current token, including the cached copy.
+func (p *ClientCredentials) Invalidate() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.token = nil
+	p.loaded = true
+	if p.Cache != nil {
+		if err := p.Cache.Clear(); err != nil {
+			p.Client.logger().Debug("unable to clear token cache", "error", err)
+		}
+	}
+}
+
+// renew refreshes the current token when it has a refresh token, falling back to the client credentials.
+func (p *ClientCredentials) renew(ctx context.Context) (*Token, error) {
+	if p.token != nil && p.token.RefreshToken != "" {
+		p.Client.logger().DebugContext(ctx, "refreshing access token", "expiresAt", p.token.ExpiresAt)
+		token, err := p.Client.RefreshToken(ctx, p.token.RefreshToken)
+		if err == nil {
+			return token, nil
+		}
+		p.Client.logger().DebugContext(ctx, "unable to refresh access token", "error", err)
+	}
+	return p.Client.Token(ctx, p.ClientID, p.ClientSecret)
+}
+
+// AuthClient is an HTTPClient that sets the Authorization header from Auth on each request.
+// When a response is 401 Unauthorized and Auth is an Invalidator, the token is discarded and
+// the request is sent once more with a new one.
+type AuthClient struct {
+	HTTPClient HTTPClient
+	Auth       AuthProvider
+	Logger     *slog.Logger // Logger is optional.
+}
+
+// Do sends req with an access token, retrying once after re-authenticating on 401.
+func (a *AuthClient) Do(req *http.Request) (*http.Response, error) {
+	if a.Auth == nil {
+		return nil, errors.New("no auth provider configured")
+	}
+	for attempt := 1; ; attempt++ {
+		token, err := a.Auth.AccessToken(req.Context())
+		if err != nil {
+			return nil, fmt.Errorf("unable to get access token: %w", err)
+		}
+		attemptReq := req.Clone(req.Context())
+		attemptReq.Header.Set("Authorization", token)
+		if attempt > 1 {
+			if attemptReq.Body, err = req.GetBody(); err != nil {
+				return nil, err //nolint:wrapcheck // only fails when the original body could not be read.
+			}
+		}
+
+		resp, err := a.HTTPClient.Do(attemptReq)
+		invalidator, ok := a.Auth.(Invalidator)
+		replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+		if err != nil || resp.StatusCode != http.StatusUnauthorized || attempt > 1 || !ok || !replayable {
+			return resp, err //nolint:wrapcheck // transparent wrapper.
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if a.Logger != nil {
+			a.Logger.DebugContext(req.Context(), "unauthorized, requesting a new access token", "url", req.URL.String())
+		}
+		invalidator.Invalidate()
+	}
+}
@@ -0,0 +1,179 @@
+package dsv_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"github.com/DelineaXPM/dsv-gitlab/dsv"
+	"github.com/DelineaXPM/dsv-gitlab/dsv/dsvtest"
+)
+
+type memoryCache struct {
+	token *dsv.Token
+}
+
+func (m *memoryCache) Load() (*dsv.Token, error) { return m.token, nil }
+func (m *memoryCache) Store(t *dsv.Token) error  { m.token = t; return nil }
+func (m *memoryCache) Clear() error              { m.token = nil; return nil }
+
+// grants returns the grant types of the token requests server received, skipping the first skip requests.
+func grants(t *testing.T, server *dsvtest.Server, skip int) []string {
+	t.Helper()
+	grants := []string{}
+	for _, r := range server.Requests()[skip:] {
+		if r.Path != "/token" {
+			continue
+		}
+		body := map[string]string{}
+		if err := json.Unmarshal(r.Body, &body); err != nil {
+			t.Fatal(err)
+		}
+		grants = append(grants, body["grant_type"])
+	}
+	return grants
+}
+
+func TestClientCredentialsCache(t *testing.T) {
+	cases := []struct {
+		name       string
+		cached     func(issued *dsv.Token) *dsv.Token
+		revoke     bool
+		wantGrants []string
+	}{
+		{
+			name:       "empty cache",
+			cached:     func(*dsv.Token) *dsv.Token { return nil },
+			wantGrants: []string{"client_credentials"},
+		},
+		{
+			name: "valid cached token",
+			cached: func(issued *dsv.Token) *dsv.Token {
+				return &dsv.Token{AccessToken: issued.AccessToken, ExpiresAt: time.Now().Add(time.Hour)}
+			},
+			wantGrants: []string{},
+		},
+		{
+			name: "cached token about to expire is refreshed",
+			cached: func(issued *dsv.Token) *dsv.Token {
+				return &dsv.Token{AccessToken: issued.AccessToken, RefreshToken: issued.RefreshToken, ExpiresAt: time.Now().Add(time.Second)}
+			},
+			wantGrants: []string{"refresh_token"},
+		},
+		{
+			name: "expired cached token without refresh token",
+			cached: func(issued *dsv.Token) *dsv.Token {
+				return &dsv.Token{AccessToken: issued.AccessToken, ExpiresAt: time.Now().Add(-time.Hour)}
+			},
+			wantGrants: []string{"client_credentials"},
+		},
+		{
+			name: "revoked cached token is replaced after 401",
+			cached: func(issued *dsv.Token) *dsv.Token {
+				return &dsv.Token{AccessToken: issued.AccessToken, ExpiresAt: time.Now().Add(time.Hour)}
+			},
+			revoke:     true,
+			wantGrants: []string{"client_credentials"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			server := dsvtest.NewServer()
+			defer server.Close()
+			server.SetSecret("a", map[string]interface{}{})
+			issued, err := server.Client().Token(context.Background(), dsvtest.ClientID, dsvtest.ClientSecret)
+			is.NoErr(err)
+			if tc.revoke {
+				server.RevokeTokens()
+			}
+			skip := len(server.Requests())
+
+			cache := &memoryCache{token: tc.cached(issued)}
+			c := &dsv.Client{BaseURL: server.BaseURL(), HTTPClient: server.Server.Client()}
+			auth := dsv.NewClientCredentials(c, dsvtest.ClientID, dsvtest.ClientSecret)
+			auth.Cache = cache
+			c.Auth = auth
+
+			_, err = c.GetSecret(context.Background(), "a")
+			is.NoErr(err)
+			is.Equal(grants(t, server, skip), tc.wantGrants) // Token endpoint should only be called when needed.
+			is.True(cache.token != nil)                      // A usable token should stay cached.
+		})
+	}
+}
+
+func TestClientCredentialsProactiveRefresh(t *testing.T) {
+	is := is.New(t)
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.TokenTTL = time.Second // The refresh skew is half of the one second lifetime.
+	server.SetSecret("a", map[string]interface{}{})
+
+	c := server.Client()
+	for i := 0; i < 3; i++ {
+		if i == 2 {
+			time.Sleep(600 * time.Millisecond)
+		}
+		_, err := c.GetSecret(context.Background(), "a")
+		is.NoErr(err)
+	}
+	is.Equal(grants(t, server, 0), []string{"client_credentials", "refresh_token"}) // Expiring tokens should be refreshed before use.
+}
+
+func TestClientCredentialsShortLivedToken(t *testing.T) {
+	is := is.New(t)
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.TokenTTL = 30 * time.Second // Shorter than the one minute refresh skew.
+	server.SetSecret("ci:db", map[string]interface{}{"password": "s3cret"})
+
+	c := server.Client()
+	for i := 0; i < 3; i++ {
+		_, err := c.GetSecret(context.Background(), "ci:db")
+		is.NoErr(err)
+	}
+	tokens := 0
+	for _, r := range server.Requests() {
+		if r.Path == "/token" {
+			tokens++
+		}
+	}
+	is.Equal(tokens, 1) // A short lived token should be reused while it is fresh.
+}
+
+func TestAuthClientRetriesOnceOnUnauthorized(t *testing.T) {
+	is := is.New(t)
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.SetSecret("b", map[string]interface{}{})
+	c := server.Client()
+	_, err := c.GetSecret(context.Background(), "b")
+	is.NoErr(err)
+	server.RevokeTokens()
+	skip := len(server.Requests())
+
+	_, err = c.CreateSecret(context.Background(), "a", &dsv.SecretInput{Data: map[string]interface{}{"k": "v"}})
+	is.NoErr(err)
+	bodies := []string{}
+	for _, r := range server.Requests()[skip:] {
+		if r.Path == "/secrets/a" {
+			bodies = append(bodies, string(r.Body))
+		}
+	}
+	is.Equal(len(grants(t, server, skip)), 1)                              // Should re-authenticate after the 401.
+	is.Equal(bodies, []string{`{"data":{"k":"v"}}`, `{"data":{"k":"v"}}`}) // Request body should be replayed.
+
+	server.Fail(dsvtest.Fault{Path: "/secrets/always-401", StatusCode: http.StatusUnauthorized})
+	skip = len(server.Requests())
+	_, err = c.GetSecret(context.Background(), "always-401")
+	var apiErr *dsv.APIError
+	is.True(errors.As(err, &apiErr)) // Should give up after one retry.
+	is.Equal(apiErr.StatusCode, http.StatusUnauthorized)
+	is.Equal(len(grants(t, server, skip)), 1)
+}
@@ -0,0 +1,285 @@
+// Package dsv is a small client for the Delinea DevOps Secrets Vault (DSV) REST API.
+package dsv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNoAccessToken is returned when the token endpoint responds without an access token.
+var ErrNoAccessToken = errors.New("could not read access token from response")
+
+// HTTPClient is the subset of *http.Client used to send requests.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// AuthProvider supplies the access token sent in the Authorization header.
+type AuthProvider interface {
+	AccessToken(ctx context.Context) (string, error)
+}
+
+// Invalidator is implemented by AuthProviders that can discard their current token,
+// so the next AccessToken call gets a new one.
+type Invalidator interface {
+	Invalidate()
+}
+
+// StaticToken is an AuthProvider for an access token that was obtained elsewhere.
+type StaticToken string
+
+// AccessToken returns the static token.
+func (t StaticToken) AccessToken(context.Context) (string, error) {
+	return string(t), nil
+}
+
+// APIError is returned when DSV responds with a non 2xx status code.
+type APIError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Status     string
+	Message    string // Message is the error message from the response body, if any.
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%s %s: %s", e.Method, e.URL, e.Status)
+	}
+	return fmt.Sprintf("%s %s: %s: %s", e.Method, e.URL, e.Status, e.Message)
+}
+
+// Client calls the DSV API.
+type Client struct {
+	BaseURL      string       // BaseURL is the API base, e.g. https://mytenant.secretsvaultcloud.com/v1.
+	HTTPClient   HTTPClient   // HTTPClient sends the requests, http.DefaultClient when nil.
+	Auth         AuthProvider // Auth provides the access token for authenticated requests.
+	ClientHeader string       // ClientHeader is sent as the Delinea-DSV-Client header when set.
+	Logger       *slog.Logger // Logger receives debug events for each request, discarded when nil.
+}
+
+// Token is the response of the token endpoint.
+//
+//nolint:tagliatelle // DSV uses camel casing.
+type Token struct {
+	AccessToken  string    `json:"accessToken"`
+	TokenType    string    `json:"tokenType"`
+	ExpiresIn    int       `json:"expiresIn"`
+	RefreshToken string    `json:"refreshToken"`
+	ExpiresAt    time.Time `json:"expiresAt,omitempty"` // ExpiresAt is computed from ExpiresIn when the token is received.
+}
+
+// Expired reports whether the token expires within skew of now.
+// Tokens without a known expiry never expire.
+func (t *Token) Expired(now time.Time, skew time.Duration) bool {
+	return !t.ExpiresAt.IsZero() && !now.Add(skew).Before(t.ExpiresAt)
+}
+
+// Secret is a DSV secret.
+//
+//nolint:tagliatelle // DSV uses camel casing.
+type Secret struct {
+	ID             string                 `json:"id"`
+	Path           string                 `json:"path"`
+	Version        string                 `json:"version"`
+	Description    string                 `json:"description,omitempty"`
+	Attributes     map[string]interface{} `json:"attributes,omitempty"`
+	Data           map[string]interface{} `json:"data"`
+	Created        time.Time              `json:"created"`
+	CreatedBy      string                 `json:"createdBy,omitempty"`
+	LastModified   time.Time              `json:"lastModified"`
+	LastModifiedBy string                 `json:"lastModifiedBy,omitempty"`
+}
+
+// SecretInput is the body for creating or updating a secret.
+type SecretInput struct {
+	Description string                 `json:"description,omitempty"`
+	Attributes  map[string]interface{} `json:"attributes,omitempty"`
+	Data        map[string]interface{} `json:"data"`
+}
+
+// Token requests an access token with client credentials.
+func (c *Client) Token(ctx context.Context, clientID, clientSecret string) (*Token, error) {
+	body := map[string]string{
+		"grant_type":    "client_credentials",
+		"client_id":     clientID,
+		"client_secret": clientSecret,
+	}
+	return c.requestToken(ctx, body)
+}
+
+// RefreshToken exchanges a refresh token for a new access token.
+func (c *Client) RefreshToken(ctx context.Context, refreshToken string) (*Token, error) {
+	body := map[string]string{
+		"grant_type":    "refresh_token",
+		"refresh_token": refreshToken,
+	}
+	return c.requestToken(ctx, body)
+}
+
+func (c *Client) requestToken(ctx context.Context, body map[string]string) (*Token, error) {
+	issued := time.Now()
+	token := &Token{}
+	if err := c.send(ctx, http.MethodPost, []string{"token"}, nil, false, body, token); err != nil {
+		return nil, err
+	}
+	if token.AccessToken == "" {
+		return nil, ErrNoAccessToken
+	}
+	if token.ExpiresIn > 0 {
+		token.ExpiresAt = issued.Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+// GetSecret reads the current version of the secret at path.
+func (c *Client) GetSecret(ctx context.Context, path string) (*Secret, error) {
+	secret := &Secret{}
+	if err := c.Do(ctx, http.MethodGet, []string{"secrets", path}, nil, secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// GetSecretVersion reads the given version of the secret at path.
+func (c *Client) GetSecretVersion(ctx context.Context, path, version string) (*Secret, error) {
+	secret := &Secret{}
+	if err := c.send(ctx, http.MethodGet, []string{"secrets", path}, url.Values{"version": {version}}, true, nil, secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// GetDynamicSecret reads the dynamic secret at path, which makes DSV issue new short-lived
+// cloud credentials. A non-zero ttl overrides the lifetime configured on the dynamic secret.
+func (c *Client) GetDynamicSecret(ctx context.Context, path string, ttl time.Duration) (*Secret, error) {
+	var query url.Values
+	if ttl > 0 {
+		query = url.Values{"ttl": {strconv.FormatInt(int64(ttl/time.Second), 10)}}
+	}
+	secret := &Secret{}
+	if err := c.send(ctx, http.MethodGet, []string{"secrets", path}, query, true, nil, secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// CreateSecret creates a secret at path, failing if it already exists.
+func (c *Client) CreateSecret(ctx context.Context, path string, in *SecretInput) (*Secret, error) {
+	secret := &Secret{}
+	if err := c.Do(ctx, http.MethodPost, []string{"secrets", path}, in, secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// UpdateSecret replaces the secret at path, creating a new version.
+func (c *Client) UpdateSecret(ctx context.Context, path string, in *SecretInput) (*Secret, error) {
+	secret := &Secret{}
+	if err := c.Do(ctx, http.MethodPut, []string{"secrets", path}, in, secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// DeleteSecret deletes the secret at path.
+func (c *Client) DeleteSecret(ctx context.Context, path string) error {
+	return c.Do(ctx, http.MethodDelete, []string{"secrets", path}, nil, nil)
+}
+
+// Do sends an authenticated request to the API path built from elems, encoding in as JSON when not nil
+// and decoding the JSON response into out when not nil.
+func (c *Client) Do(ctx context.Context, method string, elems []string, in, out any) error {
+	return c.send(ctx, method, elems, nil, true, in, out)
+}
+
+func (c *Client) send(ctx context.Context, method string, elems []string, query url.Values, authenticate bool, in, out any) error {
+	path, err := url.JoinPath("/", elems...)
+	if err != nil {
+		return fmt.Errorf("unable to build url: %w", err)
+	}
+	endpoint := strings.TrimSuffix(c.BaseURL, "/") + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	var body []byte
+	if in != nil {
+		if body, err = json.Marshal(in); err != nil {
+			return fmt.Errorf("could not marshal request body: %w", err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("could not build request: %w", err)
+	}
+	hc := c.httpClient()
+	if authenticate {
+		hc = &AuthClient{HTTPClient: hc, Auth: c.Auth, Logger: c.logger()}
+	}
+	return c.sendRequest(hc, req, out)
+}
+
+func (c *Client) sendRequest(hc HTTPClient, req *http.Request, out any) error {
+	req.Header.Set("Content-Type", "application/json")
+	if c.ClientHeader != "" {
+		req.Header.Set("Delinea-DSV-Client", c.ClientHeader)
+	}
+	start := time.Now()
+	resp, err := hc.Do(req)
+	if err != nil {
+		c.logger().DebugContext(req.Context(), "request failed", "method", req.Method, "url", req.URL.String(), "error", err)
+		return err //nolint:wrapcheck // callers wrap with their own context.
+	}
+	defer resp.Body.Close()
+	c.logger().DebugContext(req.Context(), "request", "method", req.Method, "url", req.URL.String(), "status", resp.StatusCode, "duration", time.Since(start))
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("could not read response body: %w", err)
+	}
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		apiErr := &APIError{Method: req.Method, URL: req.URL.String(), StatusCode: resp.StatusCode, Status: resp.Status}
+		var msg struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(body, &msg) == nil {
+			apiErr.Message = msg.Message
+		}
+		return apiErr
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err = json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("could not unmarshal response body: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) httpClient() HTTPClient {
+	if c.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return c.HTTPClient
+}
+
+func (c *Client) logger() *slog.Logger {
+	if c.Logger == nil {
+		return slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	return c.Logger
+}
@@ -0,0 +1,110 @@
+package dsv_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"github.com/DelineaXPM/dsv-gitlab/dsv"
+	"github.com/DelineaXPM/dsv-gitlab/dsv/dsvtest"
+)
+
+func TestClientToken(t *testing.T) {
+	is := is.New(t)
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.AddClient("id", `se"cret`)
+
+	c := &dsv.Client{BaseURL: server.BaseURL(), HTTPClient: server.Server.Client(), ClientHeader: "test"}
+	before := time.Now()
+	token, err := c.Token(context.Background(), "id", `se"cret`)
+	is.NoErr(err)
+	is.True(!token.ExpiresAt.Before(before.Add(time.Hour))) // Expiry should be computed from expiresIn.
+	is.True(!token.ExpiresAt.After(time.Now().Add(time.Hour)))
+	token.ExpiresAt = time.Time{}
+	is.Equal(token, &dsv.Token{AccessToken: "access-1", TokenType: "bearer", ExpiresIn: 3600, RefreshToken: "refresh-1"})
+
+	requests := server.Requests()
+	is.Equal(len(requests), 1)
+	r := requests[0]
+	is.Equal(r.Method, http.MethodPost)
+	is.Equal(r.Path, "/token")
+	is.Equal(r.Header.Get("Content-Type"), "application/json")
+	is.Equal(r.Header.Get("Delinea-DSV-Client"), "test")
+	is.Equal(r.Header.Get("Authorization"), "") // Token requests are not authenticated.
+	body := map[string]string{}
+	is.NoErr(json.Unmarshal(r.Body, &body))
+	is.Equal(body, map[string]string{
+		"grant_type":    "client_credentials",
+		"client_id":     "id",
+		"client_secret": `se"cret`,
+	})
+}
+
+func TestClientTokenMissing(t *testing.T) {
+	is := is.New(t)
+	server := dsvtest.NewServer()
+	defer server.Close()
+	server.Fail(dsvtest.Fault{Path: "/token", StatusCode: http.StatusOK, Body: []byte(`{}`)})
+
+	_, err := server.Client().Token(context.Background(), dsvtest.ClientID, dsvtest.ClientSecret)
+	is.True(errors.Is(err, dsv.ErrNoAccessToken)) // Should report the missing token.
+}
+
+func TestClientSecrets(t *testing.T) {
+	is := is.New(t)
+	server := dsvtest.NewServer()
+	defer server.Close()
+
+	c := &dsv.Client{BaseURL: server.BaseURL() + "/", HTTPClient: server.Server.Client()}
+	c.Auth = dsv.NewClientCredentials(c, dsvtest.ClientID, dsvtest.ClientSecret)
+	ctx := context.Background()
+
+	in := &dsv.SecretInput{Attributes: map[string]interface{}{"ttl": 60}, Data: map[string]interface{}{"value1": "taco"}}
+	created, err := c.CreateSecret(ctx, "ci:tests/secret 01", in)
+	is.NoErr(err)
+	is.Equal(created.Version, "1")
+
+	secret, err := c.GetSecret(ctx, "ci:tests/secret 01")
+	is.NoErr(err)
+	is.Equal(secret.ID, created.ID)
+	is.Equal(secret.Version, "1")
+	is.Equal(secret.Data["value1"], "taco")
+	is.Equal(secret.Attributes["ttl"], float64(60))
+	is.True(!secret.Created.IsZero()) // Timestamps should be decoded.
+	is.True(!secret.LastModified.IsZero())
+
+	in.Data["value1"] = "burrito"
+	secret, err = c.UpdateSecret(ctx, "ci:tests/secret 01", in)
+	is.NoErr(err)
+	is.Equal(secret.Version, "2")
+	is.Equal(server.Secret("ci:tests/secret 01").Data["value1"], "burrito")
+	is.NoErr(c.DeleteSecret(ctx, "ci:tests/secret 01"))
+
+	requests := server.Requests()
+	is.Equal(len(requests), 5) // Token should be requested once and reused.
+	is.Equal(requests[0].Path, "/token")
+	for _, r := range requests[1:] {
+		is.Equal(r.Path, "/secrets/ci:tests/secret 01")
+		is.Equal(r.Header.Get("Authorization"), "access-1")
+	}
+}
+
+func TestClientAPIError(t *testing.T) {
+	is := is.New(t)
+	server := dsvtest.NewServer()
+	defer server.Close()
+
+	_, err := server.Client().GetSecret(context.Background(), "missing")
+
+	var apiErr *dsv.APIError
+	is.True(errors.As(err, &apiErr)) // Should return an APIError.
+	is.Equal(apiErr.StatusCode, http.StatusNotFound)
+	is.Equal(apiErr.Message, "unable to find item with specified identifier")
+	is.Equal(err.Error(), "GET "+server.BaseURL()+"/secrets/missing: 404 Not Found: unable to find item with specified identifier")
+}
@@ -0,0 +1,492 @@
+// Package dsvtest provides an in-memory DSV API for tests of code using the dsv client.
+//
+// A Server issues access tokens for the client credentials it knows, keeps every version of the
+// secrets written to it, signs certificates with the root CAs added to it, records the requests it
+// receives, and can be told to fail or slow down:
+//
+//	server := dsvtest.NewServer()
+//	defer server.Close()
+//	server.SetSecret("ci:db", map[string]interface{}{"password": "s3cret"})
+//	client := server.Client()
+//	secret, err := client.GetSecret(ctx, "ci:db")
+package dsvtest
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DelineaXPM/dsv-gitlab/dsv"
+)
+
+// Default client credentials accepted by a new Server.
+const (
+	ClientID     = "dsvtest-client"
+	ClientSecret = "dsvtest-secret"
+)
+
+// Request is a request received by the Server.
+type Request struct {
+	Method string
+	Path   string // Path is the URL path below /v1, such as /secrets/ci:db.
+	Query  url.Values
+	Header http.Header
+	Body   []byte
+}
+
+// Fault makes the Server answer matching requests with an error instead of handling them.
+type Fault struct {
+	Method     string // Method matches any method when empty.
+	Path       string // Path is the URL path below /v1, such as /token or /secrets/ci:db, and matches any path when empty.
+	StatusCode int    // StatusCode is the error status, 500 when zero.
+	Message    string // Message is returned in the error body.
+	Times      int    // Times is how many requests fail, every request when zero.
+	// Body is sent as is instead of the error body when not nil, so a fault with a 200 StatusCode
+	// answers with a malformed response.
+	Body []byte
+	// Delay holds the response back. A Delay longer than the client waits makes the request hang
+	// until the client gives up.
+	Delay time.Duration
+}
+
+// Server is an in-memory DSV API served by an httptest.Server. Its methods are safe for concurrent use.
+type Server struct {
+	*httptest.Server
+
+	// TokenTTL is the lifetime of the access tokens issued, an hour when zero.
+	TokenTTL time.Duration
+	// SSHSigner signs the keys sent to /pki/ssh-cert, returning the certificate in authorized_keys format.
+	// The endpoint is not found while it is nil: the standard library cannot write SSH certificates, so
+	// tests bring their own, such as one running ssh-keygen. It is called with the Server locked and must
+	// not call Server methods.
+	SSHSigner func(in *dsv.SSHSigningRequest) (string, error)
+
+	mu       sync.Mutex
+	clients  map[string]string
+	tokens   map[string]bool
+	refresh  map[string]bool
+	secrets  map[string][]*dsv.Secret
+	roots    map[string]*rootCA
+	faults   []*Fault
+	latency  time.Duration
+	requests []Request
+	serial   int
+}
+
+// rootCA is a PKI root the Server signs certificates with.
+type rootCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// NewServer starts a Server accepting ClientID and ClientSecret. Callers should call Close when done.
+func NewServer() *Server {
+	s := &Server{
+		clients: map[string]string{ClientID: ClientSecret},
+		tokens:  map[string]bool{},
+		refresh: map[string]bool{},
+		secrets: map[string][]*dsv.Secret{},
+		roots:   map[string]*rootCA{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// BaseURL returns the API base URL to set as dsv.Client.BaseURL or DSV_API_URL.
+func (s *Server) BaseURL() string {
+	return s.URL + "/v1"
+}
+
+// Client returns a dsv.Client for the Server authenticating with ClientID and ClientSecret.
+func (s *Server) Client() *dsv.Client {
+	c := &dsv.Client{BaseURL: s.BaseURL(), HTTPClient: s.Server.Client()}
+	c.Auth = dsv.NewClientCredentials(c, ClientID, ClientSecret)
+	return c
+}
+
+// AddClient makes the Server accept another client ID and secret.
+func (s *Server) AddClient(id, secret string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clients[id] = secret
+}
+
+// RevokeTokens invalidates every access and refresh token issued so far, so the next
+// authenticated request gets 401 Unauthorized.
+func (s *Server) RevokeTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = map[string]bool{}
+	s.refresh = map[string]bool{}
+}
+
+// SetSecret writes a new version of the secret at path, creating it when it does not exist, and returns it.
+func (s *Server) SetSecret(path string, data map[string]interface{}) *dsv.Secret {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write(path, &dsv.SecretInput{Data: data})
+}
+
+// Secret returns the current version of the secret at path, or nil when there is none.
+func (s *Server) Secret(path string) *dsv.Secret {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	versions := s.secrets[path]
+	if len(versions) == 0 {
+		return nil
+	}
+	return copySecret(versions[len(versions)-1])
+}
+
+// Versions returns every version of the secret at path, oldest first.
+func (s *Server) Versions(path string) []*dsv.Secret {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	versions := make([]*dsv.Secret, 0, len(s.secrets[path]))
+	for _, v := range s.secrets[path] {
+		versions = append(versions, copySecret(v))
+	}
+	return versions
+}
+
+// AddRootCA creates a throwaway root CA at path, used to sign the requests to /pki/sign naming it as
+// rootCAPath, and returns its certificate.
+func (s *Server) AddRootCA(path string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(fmt.Sprintf("dsvtest: unable to generate root CA key: %v", err))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.serial++
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(int64(s.serial)),
+		Subject:               pkix.Name{CommonName: path},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		panic(fmt.Sprintf("dsvtest: unable to create root CA: %v", err))
+	}
+	cert, _ := x509.ParseCertificate(der)
+	s.roots[path] = &rootCA{cert: cert, key: key}
+	return cert
+}
+
+// Fail adds a fault. Faults are checked in the order they were added.
+func (s *Server) Fail(f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, &f)
+}
+
+// SetLatency delays every response by d.
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// Requests returns the requests received so far, oldest first.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	path := strings.TrimPrefix(r.URL.Path, "/v1")
+
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Method: r.Method, Path: path, Query: r.URL.Query(), Header: r.Header.Clone(), Body: body})
+	latency := s.latency
+	fault := s.fault(r.Method, path)
+	if fault != nil {
+		latency += fault.Delay
+	}
+	s.mu.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if fault != nil && fault.Body != nil {
+		w.WriteHeader(fault.StatusCode)
+		_, _ = w.Write(fault.Body)
+		return
+	}
+	if fault != nil {
+		writeError(w, fault.StatusCode, fault.Message)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case path == "/token" && r.Method == http.MethodPost:
+		s.token(w, body)
+	// The dsv client sends the bare token, a Bearer prefix is accepted as well.
+	case !s.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]:
+		writeError(w, http.StatusUnauthorized, "invalid or expired access token")
+	case strings.HasPrefix(path, "/secrets/"):
+		s.secret(w, r.Method, strings.TrimPrefix(path, "/secrets/"), r.URL.Query().Get("version"), body)
+	case path == "/pki/sign" && r.Method == http.MethodPost:
+		s.sign(w, body)
+	case path == "/pki/ssh-cert" && r.Method == http.MethodPost && s.SSHSigner != nil:
+		s.signSSH(w, body)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// fault returns the first fault matching the request, counting it down. Callers hold s.mu.
+func (s *Server) fault(method, path string) *Fault {
+	for i, f := range s.faults {
+		if (f.Method != "" && f.Method != method) || (f.Path != "" && f.Path != path) {
+			continue
+		}
+		matched := *f
+		if f.Times > 0 {
+			if f.Times--; f.Times == 0 {
+				s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			}
+		}
+		if matched.StatusCode == 0 {
+			matched.StatusCode = http.StatusInternalServerError
+		}
+		return &matched
+	}
+	return nil
+}
+
+// token answers the token endpoint for client credentials and refresh token grants. Callers hold s.mu.
+func (s *Server) token(w http.ResponseWriter, body []byte) {
+	//nolint:tagliatelle // The token endpoint uses snake casing.
+	var in struct {
+		GrantType    string `json:"grant_type"`
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.Unmarshal(body, &in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	switch in.GrantType {
+	case "client_credentials":
+		if secret, ok := s.clients[in.ClientID]; !ok || secret != in.ClientSecret {
+			writeError(w, http.StatusUnauthorized, "invalid client credentials")
+			return
+		}
+	case "refresh_token":
+		if !s.refresh[in.RefreshToken] {
+			writeError(w, http.StatusUnauthorized, "invalid refresh token")
+			return
+		}
+		delete(s.refresh, in.RefreshToken)
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported grant type")
+		return
+	}
+	s.serial++
+	access, refresh := fmt.Sprintf("access-%d", s.serial), fmt.Sprintf("refresh-%d", s.serial)
+	s.tokens[access] = true
+	s.refresh[refresh] = true
+	ttl := s.TokenTTL
+	if ttl == 0 {
+		ttl = time.Hour
+	}
+	writeJSON(w, http.StatusOK, &dsv.Token{AccessToken: access, TokenType: "bearer", ExpiresIn: int(ttl / time.Second), RefreshToken: refresh})
+}
+
+// secret answers the secrets endpoints. Callers hold s.mu.
+func (s *Server) secret(w http.ResponseWriter, method, path, version string, body []byte) {
+	versions := s.secrets[path]
+	switch method {
+	case http.MethodGet:
+		if len(versions) == 0 {
+			writeError(w, http.StatusNotFound, "unable to find item with specified identifier")
+			return
+		}
+		if version == "" {
+			writeJSON(w, http.StatusOK, versions[len(versions)-1])
+			return
+		}
+		for _, v := range versions {
+			if v.Version == version {
+				writeJSON(w, http.StatusOK, v)
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, "unable to find version "+version)
+	case http.MethodPost, http.MethodPut:
+		if method == http.MethodPost && len(versions) > 0 {
+			writeError(w, http.StatusBadRequest, "secret already exists")
+			return
+		}
+		if method == http.MethodPut && len(versions) == 0 {
+			writeError(w, http.StatusNotFound, "unable to find item with specified identifier")
+			return
+		}
+		in := &dsv.SecretInput{}
+		if err := json.Unmarshal(body, in); err != nil || in.Data == nil {
+			writeError(w, http.StatusBadRequest, "invalid secret")
+			return
+		}
+		writeJSON(w, http.StatusOK, s.write(path, in))
+	case http.MethodDelete:
+		if len(versions) == 0 {
+			writeError(w, http.StatusNotFound, "unable to find item with specified identifier")
+			return
+		}
+		delete(s.secrets, path)
+		writeJSON(w, http.StatusOK, struct{}{})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// sign answers the PKI signing endpoint, issuing a client certificate for the request with the
+// subject and names of the CSR. Callers hold s.mu.
+func (s *Server) sign(w http.ResponseWriter, body []byte) {
+	in := &dsv.SigningRequest{}
+	if err := json.Unmarshal(body, in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	root := s.roots[in.RootCAPath]
+	if root == nil {
+		writeError(w, http.StatusNotFound, "unable to find root CA "+in.RootCAPath)
+		return
+	}
+	var csr *x509.CertificateRequest
+	if b, err := base64.StdEncoding.DecodeString(in.CSR); err == nil {
+		if block, _ := pem.Decode(b); block != nil {
+			csr, _ = x509.ParseCertificateRequest(block.Bytes)
+		}
+	}
+	if csr == nil || csr.CheckSignature() != nil {
+		writeError(w, http.StatusBadRequest, "invalid csr")
+		return
+	}
+	ttl := time.Hour
+	if in.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(in.TTL); err != nil || ttl <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid ttl")
+			return
+		}
+	}
+	s.serial++
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(int64(s.serial)),
+		Subject:      csr.Subject,
+		DNSNames:     csr.DNSNames,
+		IPAddresses:  csr.IPAddresses,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(ttl).Truncate(time.Second),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, root.cert, csr.PublicKey, root.key)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "unable to sign certificate")
+		return
+	}
+	// Like the API, certificates are returned base64 encoded PEM.
+	signed := &dsv.SignedCertificate{Certificate: encodePEM(der)}
+	if in.Chain {
+		signed.Chain = encodePEM(root.cert.Raw)
+	}
+	writeJSON(w, http.StatusOK, signed)
+}
+
+// signSSH answers the SSH signing endpoint with SSHSigner. Callers hold s.mu.
+func (s *Server) signSSH(w http.ResponseWriter, body []byte) {
+	in := &dsv.SSHSigningRequest{}
+	if err := json.Unmarshal(body, in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	cert, err := s.SSHSigner(in)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, &dsv.SSHCertificate{Certificate: cert})
+}
+
+// encodePEM returns der as a base64 encoded PEM certificate.
+func encodePEM(der []byte) string {
+	return base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+// write appends a version of the secret at path. Callers hold s.mu.
+func (s *Server) write(path string, in *dsv.SecretInput) *dsv.Secret {
+	now := time.Now().UTC()
+	versions := s.secrets[path]
+	secret := &dsv.Secret{
+		Path:           path,
+		Version:        strconv.Itoa(len(versions) + 1),
+		Description:    in.Description,
+		Attributes:     in.Attributes,
+		Data:           in.Data,
+		Created:        now,
+		CreatedBy:      ClientID,
+		LastModified:   now,
+		LastModifiedBy: ClientID,
+	}
+	if len(versions) > 0 {
+		secret.ID = versions[0].ID
+		secret.Created = versions[0].Created
+	} else {
+		s.serial++
+		secret.ID = fmt.Sprintf("secret-%d", s.serial)
+	}
+	s.secrets[path] = append(versions, secret)
+	return copySecret(secret)
+}
+
+// copySecret returns a deep copy of secret so callers cannot change the stored versions.
+func copySecret(secret *dsv.Secret) *dsv.Secret {
+	b, _ := json.Marshal(secret)
+	c := &dsv.Secret{}
+	_ = json.Unmarshal(b, c)
+	return c
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		status, b = http.StatusInternalServerError, []byte(`{"message":"unable to encode response"}`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = io.Copy(w, bytes.NewReader(b))
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"message": message})
+}
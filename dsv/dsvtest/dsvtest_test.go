@@ -0,0 +1,175 @@
+package dsvtest_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"github.com/DelineaXPM/dsv-gitlab/dsv"
+	"github.com/DelineaXPM/dsv-gitlab/dsv/dsvtest"
+)
+
+func TestServerSecrets(t *testing.T) {
+	is := is.New(t)
+	server := dsvtest.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+	client := server.Client()
+
+	created, err := client.CreateSecret(ctx, "ci:db", &dsv.SecretInput{Description: "db", Data: map[string]interface{}{"password": "one"}})
+	is.NoErr(err)
+	is.Equal(created.Version, "1")
+	_, err = client.CreateSecret(ctx, "ci:db", &dsv.SecretInput{Data: map[string]interface{}{}})
+	var apiErr *dsv.APIError
+	is.True(errors.As(err, &apiErr)) // Creating an existing secret should fail.
+	is.Equal(apiErr.StatusCode, http.StatusBadRequest)
+
+	updated, err := client.UpdateSecret(ctx, "ci:db", &dsv.SecretInput{Data: map[string]interface{}{"password": "two"}})
+	is.NoErr(err)
+	is.Equal(updated.Version, "2")
+	is.Equal(updated.ID, created.ID) // Versions should share the secret ID.
+
+	current, err := client.GetSecret(ctx, "ci:db")
+	is.NoErr(err)
+	is.Equal(current.Data["password"], "two")
+	first, err := client.GetSecretVersion(ctx, "ci:db", "1")
+	is.NoErr(err)
+	is.Equal(first.Data["password"], "one")
+	is.Equal(len(server.Versions("ci:db")), 2)
+
+	is.NoErr(client.DeleteSecret(ctx, "ci:db"))
+	_, err = client.GetSecret(ctx, "ci:db")
+	is.True(errors.As(err, &apiErr)) // Deleted secrets should not be found.
+	is.Equal(apiErr.StatusCode, http.StatusNotFound)
+	is.Equal(server.Secret("ci:db"), nil)
+}
+
+func TestServerAuthentication(t *testing.T) {
+	is := is.New(t)
+	server := dsvtest.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+	server.SetSecret("ci:db", map[string]interface{}{"password": "one"})
+
+	bad := &dsv.Client{BaseURL: server.BaseURL()}
+	bad.Auth = dsv.NewClientCredentials(bad, dsvtest.ClientID, "wrong")
+	_, err := bad.GetSecret(ctx, "ci:db")
+	is.True(err != nil) // Wrong client secret should be rejected.
+
+	unauthenticated := &dsv.Client{BaseURL: server.BaseURL(), Auth: dsv.StaticToken("made-up")}
+	_, err = unauthenticated.GetSecret(ctx, "ci:db")
+	var apiErr *dsv.APIError
+	is.True(errors.As(err, &apiErr)) // Unknown tokens should be rejected.
+	is.Equal(apiErr.StatusCode, http.StatusUnauthorized)
+
+	client := server.Client()
+	client.ClientHeader = "test"
+	_, err = client.GetSecret(ctx, "ci:db")
+	is.NoErr(err)
+	server.RevokeTokens()
+	_, err = client.GetSecret(ctx, "ci:db")
+	is.NoErr(err) // The client should authenticate again after a 401.
+
+	requests := server.Requests()
+	paths := make([]string, 0, len(requests))
+	for _, r := range requests {
+		paths = append(paths, r.Method+" "+r.Path)
+	}
+	is.Equal(paths[len(paths)-5:], []string{
+		"POST /token", "GET /secrets/ci:db", "GET /secrets/ci:db", "POST /token", "GET /secrets/ci:db",
+	})
+	last := requests[len(requests)-1]
+	is.True(strings.HasPrefix(last.Header.Get("Authorization"), "access-"))
+	is.True(last.Header.Get("Authorization") != requests[len(requests)-2].Header.Get("Authorization")) // A new token should be used.
+	is.Equal(last.Header.Get("Delinea-DSV-Client"), "test")
+}
+
+func TestServerFaults(t *testing.T) {
+	is := is.New(t)
+	server := dsvtest.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+	server.SetSecret("ci:db", map[string]interface{}{"password": "one"})
+	client := server.Client()
+
+	server.Fail(dsvtest.Fault{Method: http.MethodGet, Path: "/secrets/ci:db", StatusCode: http.StatusForbidden, Message: "denied", Times: 1})
+	_, err := client.GetSecret(ctx, "ci:db")
+	var apiErr *dsv.APIError
+	is.True(errors.As(err, &apiErr)) // The fault should be returned.
+	is.Equal(apiErr.StatusCode, http.StatusForbidden)
+	is.Equal(apiErr.Message, "denied")
+	_, err = client.GetSecret(ctx, "ci:db")
+	is.NoErr(err) // The fault should only apply once.
+
+	server.Fail(dsvtest.Fault{Path: "/secrets/ci:db", StatusCode: http.StatusOK, Body: []byte(`{"data":`), Times: 1})
+	_, err = client.GetSecret(ctx, "ci:db")
+	is.True(err != nil && !errors.As(err, &apiErr)) // A malformed body should fail to decode.
+
+	server.Fail(dsvtest.Fault{Path: "/secrets/ci:db", Delay: time.Second, Times: 1})
+	hanging, cancelHanging := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancelHanging()
+	_, err = client.GetSecret(hanging, "ci:db")
+	is.True(errors.Is(err, context.DeadlineExceeded)) // A delayed fault should outlast the deadline.
+	_, err = client.GetSecret(ctx, "ci:db")
+	is.NoErr(err) // Other requests should not be delayed.
+
+	server.SetLatency(time.Second)
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = client.GetSecret(timeout, "ci:db")
+	is.True(errors.Is(err, context.DeadlineExceeded)) // Latency should outlast the deadline.
+}
+
+func TestServerSigning(t *testing.T) {
+	is := is.New(t)
+	server := dsvtest.NewServer()
+	defer server.Close()
+	ctx := context.Background()
+	client := server.Client()
+	root := server.AddRootCA("ci-root")
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	is.NoErr(err)
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "deploy"}, DNSNames: []string{"deploy.example.com"}}, key)
+	is.NoErr(err)
+	csr := base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+
+	signed, err := client.SignCertificate(ctx, &dsv.SigningRequest{RootCAPath: "ci-root", CSR: csr, TTL: "30m", Chain: true})
+	is.NoErr(err)
+	block, _ := pem.Decode([]byte(signed.Certificate))
+	is.True(block != nil) // Certificate should be PEM.
+	cert, err := x509.ParseCertificate(block.Bytes)
+	is.NoErr(err)
+	is.NoErr(cert.CheckSignatureFrom(root)) // Certificate should be signed by the root CA.
+	is.Equal(cert.DNSNames, []string{"deploy.example.com"})
+	is.True(cert.NotAfter.Before(time.Now().Add(31 * time.Minute))) // TTL should be applied.
+	chain, _ := pem.Decode([]byte(signed.Chain))
+	is.Equal(chain.Bytes, root.Raw) // Chain should be the root CA.
+
+	_, err = client.SignCertificate(ctx, &dsv.SigningRequest{RootCAPath: "other", CSR: csr})
+	var apiErr *dsv.APIError
+	is.True(errors.As(err, &apiErr)) // Unknown roots should not be found.
+	is.Equal(apiErr.StatusCode, http.StatusNotFound)
+
+	_, err = client.SignSSHKey(ctx, &dsv.SSHSigningRequest{RootCAPath: "ci-root", PublicKey: "ssh-ed25519 AAAA"})
+	is.True(errors.As(err, &apiErr)) // SSH signing should not be found without a signer.
+	is.Equal(apiErr.StatusCode, http.StatusNotFound)
+	server.SSHSigner = func(in *dsv.SSHSigningRequest) (string, error) {
+		return "ssh-ed25519-cert-v01@openssh.com " + strings.Join(in.Principals, ","), nil
+	}
+	sshCert, err := client.SignSSHKey(ctx, &dsv.SSHSigningRequest{RootCAPath: "ci-root", PublicKey: "ssh-ed25519 AAAA", Principals: []string{"deploy"}})
+	is.NoErr(err)
+	is.Equal(sshCert.Certificate, "ssh-ed25519-cert-v01@openssh.com deploy")
+}
@@ -0,0 +1,70 @@
+package dsv
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// SigningRequest is the body for signing a certificate signing request with a DSV PKI root.
+type SigningRequest struct {
+	RootCAPath      string   `json:"rootCAPath"`
+	CSR             string   `json:"csr"` // CSR is the PEM encoded request, base64 encoded.
+	SubjectAltNames []string `json:"subjectAltNames,omitempty"`
+	TTL             string   `json:"ttl,omitempty"` // TTL is a duration such as 30m, 12h or 7d.
+	Chain           bool     `json:"chain"`         // Chain asks for the issuing CA certificates to be included.
+}
+
+// SignedCertificate is the response of the PKI signing endpoint.
+type SignedCertificate struct {
+	Certificate string `json:"certificate"`
+	Chain       string `json:"chain,omitempty"`
+}
+
+// SignCertificate asks DSV to sign a certificate signing request with the root CA at in.RootCAPath.
+// The returned certificate and chain are PEM encoded.
+func (c *Client) SignCertificate(ctx context.Context, in *SigningRequest) (*SignedCertificate, error) {
+	signed := &SignedCertificate{}
+	if err := c.Do(ctx, http.MethodPost, []string{"pki", "sign"}, in, signed); err != nil {
+		return nil, err
+	}
+	signed.Certificate = decodePEM(signed.Certificate)
+	signed.Chain = decodePEM(signed.Chain)
+	return signed, nil
+}
+
+// decodePEM returns s as PEM, removing the base64 encoding the API applies to certificates.
+func decodePEM(s string) string {
+	if s == "" || strings.HasPrefix(strings.TrimSpace(s), "-----BEGIN") {
+		return s
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return s
+	}
+	return string(b)
+}
+
+// SSHSigningRequest is the body for signing an SSH public key with a DSV PKI root.
+type SSHSigningRequest struct {
+	RootCAPath string   `json:"rootCAPath"`
+	LeafCAPath string   `json:"leafCAPath,omitempty"`
+	PublicKey  string   `json:"publicKey"` // PublicKey is in authorized_keys format.
+	Principals []string `json:"principals"`
+	TTL        string   `json:"ttl,omitempty"`
+}
+
+// SSHCertificate is the response of the SSH signing endpoint.
+type SSHCertificate struct {
+	Certificate string `json:"sshCertificate"` // Certificate is in authorized_keys format.
+}
+
+// SignSSHKey asks DSV to sign an SSH public key for the given principals.
+func (c *Client) SignSSHKey(ctx context.Context, in *SSHSigningRequest) (*SSHCertificate, error) {
+	cert := &SSHCertificate{}
+	if err := c.Do(ctx, http.MethodPost, []string{"pki", "ssh-cert"}, in, cert); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
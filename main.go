@@ -1,17 +1,51 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 
 	"github.com/DelineaXPM/dsv-gitlab/dga"
 	"github.com/pterm/pterm"
 )
 
+// Exit codes, one per dga.ErrorKind, so pipelines can tell failures apart with allow_failure:exit_codes.
+// Flag parsing errors exit with 2 as well.
+//
+//	0  success
+//	1  any other failure, such as being unable to write an output file
+//	2  invalid configuration: missing or invalid variables, DSV_RETRIEVE or flags, an untrusted
+//	   DSV certificate, or a token request rejected with a status other than 401 or 403
+//	3  authentication failed: DSV rejected the client credentials with 401 or 403
+//	4  permission denied: DSV returned 403 Forbidden for a path
+//	5  not found: a secret, or a key in its data, does not exist
+//	6  network error or timeout: DNS or connection failure, DSV_TOTAL_TIMEOUT passed, 408, 429 or 5xx responses
 const (
-	// ExitFailure is exit code sent for failed task.
-	exitFailure = 1
 	// ExitSuccess is exit code sent for running without any error.
 	exitSuccess = 0
+	// ExitFailure is exit code sent for failed task.
+	exitFailure = 1
+	// ExitConfig is sent for missing or invalid configuration.
+	exitConfig = 2
+	// ExitAuth is sent when DSV rejects the client credentials.
+	exitAuth = 3
+	// ExitPermission is sent when DSV denies access to a path.
+	exitPermission = 4
+	// ExitNotFound is sent when a secret or data key does not exist.
+	exitNotFound = 5
+	// ExitNetwork is sent when DSV cannot be reached, times out or is unavailable.
+	exitNetwork = 6
+
+	// dockerHelperPrefix is the name prefix docker looks for credential helpers under.
+	dockerHelperPrefix = "docker-credential-"
 )
 
 //nolint:gochecknoglobals // ok for providing as version output
@@ -22,12 +56,192 @@ var (
 )
 
 func main() {
-	pterm.Info.Printf("version: %s\n"+"commit: %s\n"+"built: %s\n", version, commit, date)
+	args := os.Args[1:]
+	if strings.HasPrefix(filepath.Base(os.Args[0]), dockerHelperPrefix) {
+		// Installed as docker-credential-<name>, docker passes the operation as the only argument.
+		args = append([]string{"docker-credential"}, args...)
+	}
+	helper := isHelper(args)
+	if helper {
+		// Credential helpers answer on stdout, so everything else goes to stderr.
+		pterm.SetDefaultOutput(os.Stderr)
+	}
+	logger := newLogger()
+	if !helper {
+		logger.Info("dsv-gitlab", "version", version, "commit", commit, "built", date)
+	}
 
-	if err := dga.Run(); err != nil {
-		pterm.Error.Printfln("run(): %v", err)
-		os.Exit(exitFailure)
+	// GitLab sends SIGTERM when a job is cancelled or times out.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := run(ctx, args)
+	stop()
+	if err != nil {
+		code := exitCode(err)
+		logger.Error("run failed", "error", err, "exitCode", code, "kind", dga.KindOf(err).String())
+		os.Exit(code)
+	}
+	if !helper {
+		logger.Info("complete", "status", "success")
 	}
-	pterm.Success.Println("complete with success")
 	os.Exit(exitSuccess)
 }
+
+// newLogger returns the logger for DSV_LOG_FORMAT and DSV_LOG_LEVEL, so the lines main prints match
+// the output of the command. Invalid settings are reported by the command, the default logger is used for them.
+func newLogger() *slog.Logger {
+	debug, _ := strconv.ParseBool(os.Getenv("CI_DEBUG_TRACE"))
+	logger, err := dga.NewLogger(os.Getenv("DSV_LOG_FORMAT"), os.Getenv("DSV_LOG_LEVEL"), debug, os.Stderr)
+	if err != nil {
+		logger, _ = dga.NewLogger("", "", debug, os.Stderr)
+	}
+	return logger
+}
+
+// exitCode returns the exit code for the kind of err.
+func exitCode(err error) int {
+	switch dga.KindOf(err) {
+	case dga.ErrorConfig:
+		return exitConfig
+	case dga.ErrorAuth:
+		return exitAuth
+	case dga.ErrorPermission:
+		return exitPermission
+	case dga.ErrorNotFound:
+		return exitNotFound
+	case dga.ErrorNetwork:
+		return exitNetwork
+	default:
+		return exitFailure
+	}
+}
+
+// isHelper reports whether args run a credential helper, whose stdout is read by another program.
+func isHelper(args []string) bool {
+	return len(args) > 0 && (args[0] == "git-credential" || args[0] == "docker-credential")
+}
+
+// run dispatches to the subcommand named by the first argument, retrieving secrets when there is none.
+func run(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "store":
+			return runStore(ctx, args[1:])
+		case "rotate":
+			return runRotate(ctx, args[1:])
+		case "git-credential":
+			return runGitCredential(ctx, args[1:])
+		case "docker-credential":
+			return runDockerCredential(ctx, args[1:])
+		}
+	}
+	return runRetrieve(ctx, args)
+}
+
+// runRetrieve retrieves the secrets listed in DSV_RETRIEVE.
+func runRetrieve(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("dsv-gitlab", flag.ExitOnError)
+	opts := dga.Options{}
+	localFlags(fs, &opts)
+	fs.BoolVar(&opts.ShowValues, "show-values", false, "print secret values in local mode instead of masking them")
+	fs.StringVar(&opts.OutFile, "out", "", "local mode: write resolved variables to this dotenv file instead of printing them")
+	_ = fs.Parse(args)
+	return dga.Run(ctx, opts)
+}
+
+// runStore writes a secret to DSV from DSV_STORE and the command line.
+func runStore(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("dsv-gitlab store", flag.ExitOnError)
+	opts := dga.Options{}
+	localFlags(fs, &opts)
+	spec := dga.StoreSpec{FromEnv: map[string]string{}, FromFile: map[string]string{}}
+	fs.StringVar(&spec.Path, "path", "", "secret path to write")
+	fs.StringVar(&spec.Mode, "mode", "", "upsert (default) to create or update, create to fail when the secret exists")
+	fs.BoolVar(&spec.Merge, "merge", false, "merge into the existing data and attributes instead of replacing them")
+	fs.StringVar(&spec.Description, "description", "", "secret description")
+	fs.StringVar(&spec.DataFile, "data-file", "", "JSON or YAML file with the secret data")
+	fs.Var(keyValueFlag(spec.FromEnv), "from-env", "data `key=ENV_VAR` to read a value from an environment variable, repeatable")
+	fs.Var(keyValueFlag(spec.FromFile), "from-file", "data `key=path` to read a value from a file, repeatable")
+	_ = fs.Parse(args)
+	return dga.Store(ctx, opts, spec)
+}
+
+// runRotate writes a new random value for a secret key from DSV_ROTATE and the command line.
+func runRotate(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("dsv-gitlab rotate", flag.ExitOnError)
+	opts := dga.Options{}
+	localFlags(fs, &opts)
+	fs.BoolVar(&opts.ShowValues, "show-values", false, "print the new value in local mode instead of masking it")
+	fs.StringVar(&opts.OutFile, "out", "", "local mode: write the exported variables to this dotenv file instead of printing them")
+	spec := dga.RotateSpec{}
+	var classes string
+	fs.StringVar(&spec.Path, "path", "", "secret path to rotate")
+	fs.StringVar(&spec.Key, "key", "", "data key to set to the new value")
+	fs.StringVar(&spec.Generator, "generator", "", "password (default), uuid, hex or base64")
+	fs.IntVar(&spec.Length, "length", 0, "password length, or number of random bytes for hex and base64 (default 32)")
+	fs.StringVar(&classes, "classes", "", "comma separated password character classes: lower, upper, digits, symbols (default lower,upper,digits)")
+	fs.StringVar(&spec.OutputVariable, "output-variable", "", "export the new value and versions to the dotenv file under this name")
+	_ = fs.Parse(args)
+	if classes != "" {
+		spec.Classes = strings.Split(classes, ",")
+	}
+	return dga.Rotate(ctx, opts, spec)
+}
+
+// runGitCredential answers a git credential helper request from DSV_GIT_CREDENTIALS. Git passes the
+// operation, get, store or erase, as the last argument.
+func runGitCredential(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("dsv-gitlab git-credential", flag.ExitOnError)
+	opts := dga.Options{}
+	localFlags(fs, &opts)
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		return &dga.Error{Kind: dga.ErrorConfig, Err: fmt.Errorf("expected one operation argument, %s, %s or %s", dga.GitCredentialGet, dga.GitCredentialStore, dga.GitCredentialErase)}
+	}
+	return dga.GitCredential(ctx, opts, fs.Arg(0), os.Stdin, os.Stdout)
+}
+
+// runDockerCredential answers a docker credential helper request from the registries in
+// DSV_DOCKER_CREDENTIALS_FILE. When docker does not find the registry, the message it expects is
+// written to stdout.
+func runDockerCredential(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("dsv-gitlab docker-credential", flag.ExitOnError)
+	opts := dga.Options{}
+	localFlags(fs, &opts)
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		return &dga.Error{Kind: dga.ErrorConfig, Err: fmt.Errorf("expected one operation argument, %s or %s", dga.DockerCredentialGet, dga.DockerCredentialList)}
+	}
+	err := dga.DockerCredential(ctx, opts, fs.Arg(0), os.Stdin, os.Stdout)
+	if errors.Is(err, dga.ErrCredentialsNotFound) {
+		fmt.Fprintln(os.Stdout, err)
+	}
+	return err
+}
+
+// localFlags registers the local development mode flags shared by all subcommands.
+func localFlags(fs *flag.FlagSet, opts *dga.Options) {
+	fs.BoolVar(&opts.Local, "local", false, "run in local development mode, no GitLab CI variables required (default when GITLAB_CI is not set)")
+	fs.StringVar(&opts.EnvFile, "env-file", "", "local mode: read DSV_* variables from this file (default .env if present)")
+	fs.StringVar(&opts.ConfigFile, "config", "", "local mode: DSV CLI config file to read client credentials from (default ~/.dsv.yml if present)")
+	fs.StringVar(&opts.Profile, "profile", "", "local mode: DSV CLI profile to use (default \"default\")")
+}
+
+// keyValueFlag collects repeated key=value flags into a map.
+type keyValueFlag map[string]string
+
+func (f keyValueFlag) String() string {
+	pairs := make([]string, 0, len(f))
+	for k, v := range f {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (f keyValueFlag) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || k == "" || v == "" {
+		return fmt.Errorf("expected key=value, got %q", s)
+	}
+	f[k] = v
+	return nil
+}